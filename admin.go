@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"wa-server-go/storage"
+	"wa-server-go/whatsapp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var countryCodeRegex = regexp.MustCompile(`^[0-9]{1,3}$`)
+
+// adminUserView adds the live WhatsApp connection state to a user summary.
+func adminUserView(u storage.UserSummary) fiber.Map {
+	view := fiber.Map{
+		"id":             u.ID,
+		"email":          u.Email,
+		"role":           u.Role,
+		"disabled":       u.Disabled,
+		"createdAt":      u.CreatedAt,
+		"totpEnabled":    u.TotpEnabled,
+		"totpRequired":   u.TotpRequired,
+		"activeSessions": u.ActiveSessions,
+		"lockedUntil":    nil,
+		"whatsapp":       fiber.Map{"status": "disconnected", "info": nil},
+	}
+	if u.LockedUntil != "" {
+		view["lockedUntil"] = u.LockedUntil
+	}
+	if uc, ok := whatsapp.PeekUserClient(u.ID); ok {
+		view["whatsapp"] = fiber.Map{"status": uc.ConnectionStatus, "info": uc.ClientInfo}
+	}
+	return view
+}
+
+func registerAdminRoutes(admin fiber.Router) {
+	admin.Get("/users", func(c *fiber.Ctx) error {
+		users := storage.ListUsers()
+		result := make([]fiber.Map, 0, len(users))
+		for _, u := range users {
+			result = append(result, adminUserView(u))
+		}
+		return c.JSON(result)
+	})
+
+	admin.Get("/users/:id", func(c *fiber.Ctx) error {
+		for _, u := range storage.ListUsers() {
+			if u.ID == c.Params("id") {
+				return c.JSON(adminUserView(u))
+			}
+		}
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	})
+
+	admin.Post("/users/:id/disable", func(c *fiber.Ctx) error {
+		if c.Params("id") == c.Locals("userId").(string) {
+			return c.Status(400).JSON(fiber.Map{"error": "You cannot disable your own account"})
+		}
+		user, err := storage.SetUserDisabled(c.Params("id"), true)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, user.ID, "admin.user_disabled", nil)
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Post("/users/:id/enable", func(c *fiber.Ctx) error {
+		user, err := storage.SetUserDisabled(c.Params("id"), false)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, user.ID, "admin.user_enabled", nil)
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Post("/users/:id/unlock", func(c *fiber.Ctx) error {
+		user, err := storage.UnlockUser(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		limiter.reset(emailKey(user.Email))
+		auditFor(c, user.ID, "admin.user_unlocked", nil)
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Post("/users/:id/role", func(c *fiber.Ctx) error {
+		type Req struct {
+			Role string `json:"role"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if c.Params("id") == c.Locals("userId").(string) && body.Role != storage.RoleAdmin {
+			return c.Status(400).JSON(fiber.Map{"error": "You cannot remove your own admin role"})
+		}
+		user, err := storage.SetUserRole(c.Params("id"), body.Role)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, user.ID, "admin.role_changed", map[string]interface{}{"role": body.Role})
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Post("/users/:id/logout", func(c *fiber.Ctx) error {
+		if storage.FindUserByID(c.Params("id")) == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		revoked := storage.RevokeAllSessions(c.Params("id"), "")
+		auditFor(c, c.Params("id"), "admin.forced_logout", map[string]interface{}{"revoked": revoked})
+		return c.JSON(fiber.Map{"success": true, "revoked": revoked})
+	})
+
+	admin.Post("/users/:id/reset-password", func(c *fiber.Ctx) error {
+		type Req struct {
+			NewPassword string `json:"newPassword"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		user, err := storage.AdminSetPassword(c.Params("id"), body.NewPassword)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, user.ID, "admin.password_reset", nil)
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Post("/users/:id/require-2fa", func(c *fiber.Ctx) error {
+		type Req struct {
+			Required bool `json:"required"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		user, err := storage.SetTotpRequired(c.Params("id"), body.Required)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, user.ID, "admin.2fa_required", map[string]interface{}{"required": body.Required})
+		return c.JSON(adminUserView(*user))
+	})
+
+	admin.Delete("/users/:id", func(c *fiber.Ctx) error {
+		userId := c.Params("id")
+		if userId == c.Locals("userId").(string) {
+			return c.Status(400).JSON(fiber.Map{"error": "You cannot delete your own account"})
+		}
+		target := storage.FindUserByID(userId)
+		if target == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		whatsapp.RemoveUserClient(userId)
+		orphaned, transfers := storage.RemoveUserFromOrganizations(userId)
+		for _, orgId := range orphaned {
+			whatsapp.RemoveUserClient(orgId)
+			storage.DeleteOrganization(orgId)
+		}
+		for _, t := range transfers {
+			auditFor(c, t.OrgID, "org.ownership_transferred", map[string]interface{}{
+				"userId":       t.UserID,
+				"previousRole": t.PreviousRole,
+				"reason":       "owner_deleted",
+			})
+		}
+		if err := storage.DeleteUser(userId); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// The target's own log is deleted with their data, so record it in ours
+		audit(c, "admin.user_deleted", map[string]interface{}{"userId": userId, "email": target.Email})
+		fmt.Printf("🗑️  [%.8s] Account deleted by admin %s\n", userId, c.Locals("userEmail"))
+		return c.JSON(fiber.Map{"success": true, "message": "User deleted"})
+	})
+
+	admin.Get("/settings", func(c *fiber.Ctx) error {
+		config := storage.GetGlobalConfig().Config
+		return c.JSON(fiber.Map{
+			"registrationOpen":          !config.RegistrationClosed,
+			"require2FA":                config.Require2FA,
+			"rejectUnregisteredNumbers": config.RejectUnregisteredNumbers,
+			"defaultCountryCode":        config.DefaultCountryCode,
+		})
+	})
+
+	admin.Put("/settings", func(c *fiber.Ctx) error {
+		type Req struct {
+			RegistrationOpen          *bool   `json:"registrationOpen"`
+			Require2FA                *bool   `json:"require2FA"`
+			RejectUnregisteredNumbers *bool   `json:"rejectUnregisteredNumbers"`
+			DefaultCountryCode        *string `json:"defaultCountryCode"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.DefaultCountryCode != nil {
+			cc := strings.TrimPrefix(strings.TrimSpace(*body.DefaultCountryCode), "+")
+			if cc != "" && !countryCodeRegex.MatchString(cc) {
+				return c.Status(400).JSON(fiber.Map{"error": "defaultCountryCode must be 1 to 3 digits"})
+			}
+			body.DefaultCountryCode = &cc
+		}
+		updated := storage.UpdateGlobalConfig(func(conf *storage.ServerConfig) {
+			if body.RegistrationOpen != nil {
+				conf.RegistrationClosed = !*body.RegistrationOpen
+			}
+			if body.Require2FA != nil {
+				conf.Require2FA = *body.Require2FA
+			}
+			if body.RejectUnregisteredNumbers != nil {
+				conf.RejectUnregisteredNumbers = *body.RejectUnregisteredNumbers
+			}
+			if body.DefaultCountryCode != nil {
+				conf.DefaultCountryCode = *body.DefaultCountryCode
+			}
+		}).Config
+		settings := fiber.Map{
+			"registrationOpen":          !updated.RegistrationClosed,
+			"require2FA":                updated.Require2FA,
+			"rejectUnregisteredNumbers": updated.RejectUnregisteredNumbers,
+			"defaultCountryCode":        updated.DefaultCountryCode,
+		}
+		audit(c, "admin.settings_updated", settings)
+		return c.JSON(settings)
+	})
+}
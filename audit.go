@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"wa-server-go/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// audit records an action taken by the authenticated user in the audit log
+// of the instance the request acts on: their own, or their organization's.
+func audit(c *fiber.Ctx, action string, details map[string]interface{}) {
+	logId, _ := c.Locals("instanceId").(string)
+	if logId == "" {
+		logId, _ = c.Locals("userId").(string)
+	}
+	auditFor(c, logId, action, details)
+}
+
+// auditFor records an action in userId's audit log, attributing it to
+// whoever made the request. Admin actions use it to log into the target
+// user's trail.
+func auditFor(c *fiber.Ctx, userId string, action string, details map[string]interface{}) {
+	actor, _ := c.Locals("userEmail").(string)
+	credential, _ := c.Locals("credential").(string)
+	storage.RecordAudit(storage.AuditEvent{
+		UserID:     userId,
+		Action:     action,
+		Actor:      actor,
+		IP:         c.IP(),
+		UserAgent:  c.Get("User-Agent"),
+		Credential: credential,
+		Details:    details,
+	})
+}
+
+func parseAuditFilter(c *fiber.Ctx) (storage.AuditFilter, error) {
+	filter := storage.AuditFilter{
+		Action:     c.Query("action"),
+		Actor:      c.Query("actor"),
+		IP:         c.Query("ip"),
+		Credential: c.Query("credential"),
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return filter, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*dst = n
+		}
+	}
+	return filter, nil
+}
+
+// auditHandlers returns the query and JSONL export handlers for the audit
+// log of the user chosen by userOf.
+func auditHandlers(userOf func(c *fiber.Ctx) string) (fiber.Handler, fiber.Handler) {
+	query := func(c *fiber.Ctx) error {
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if filter.Limit == 0 {
+			filter.Limit = 100
+		}
+		return c.JSON(storage.QueryAudit(userOf(c), filter))
+	}
+	export := func(c *fiber.Ctx) error {
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set("Content-Type", "application/x-ndjson")
+		c.Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		return storage.ExportAudit(userOf(c), filter, c.Response().BodyWriter())
+	}
+	return query, export
+}
+
+func registerAuditRoutes(api fiber.Router, admin fiber.Router) {
+	query, export := auditHandlers(func(c *fiber.Ctx) string {
+		return c.Locals("instanceId").(string)
+	})
+	api.Get("/audit", requireOrgRole(storage.OrgRoleAdmin), query)
+	api.Get("/audit/export", requireOrgRole(storage.OrgRoleAdmin), export)
+
+	query, export = auditHandlers(func(c *fiber.Ctx) string {
+		return c.Params("id")
+	})
+	admin.Get("/users/:id/audit", query)
+	admin.Get("/users/:id/audit/export", export)
+
+	// The system log holds events not tied to an account, e.g. throttled IPs
+	query, export = auditHandlers(func(c *fiber.Ctx) string {
+		return ""
+	})
+	admin.Get("/audit", query)
+	admin.Get("/audit/export", export)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -9,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"wa-server-go/notify"
 	"wa-server-go/storage"
 	"wa-server-go/whatsapp"
 
@@ -16,16 +21,19 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/skip2/go-qrcode"
 )
 
-func authMiddleware(c *fiber.Ctx) error {
-	var token string
+func requestToken(c *fiber.Ctx) string {
 	authHeader := c.Get("Authorization")
 	if strings.HasPrefix(authHeader, "Bearer ") {
-		token = strings.TrimPrefix(authHeader, "Bearer ")
-	} else {
-		token = c.Cookies("wa_token")
+		return strings.TrimPrefix(authHeader, "Bearer ")
 	}
+	return c.Cookies("wa_token")
+}
+
+func authMiddleware(c *fiber.Ctx) error {
+	token := requestToken(c)
 
 	if token == "" {
 		if strings.HasPrefix(c.Path(), "/api/") {
@@ -34,7 +42,7 @@ func authMiddleware(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
-	user := storage.FindUserByToken(token)
+	user, session := storage.FindUserBySession(token, c.IP())
 	if user == nil {
 		if strings.HasPrefix(c.Path(), "/api/") {
 			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized — please log in"})
@@ -42,14 +50,82 @@ func authMiddleware(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
+	if strings.HasPrefix(c.Path(), "/api/") && !user.TotpEnabled && storage.TwoFactorRequired(user) {
+		return c.Status(403).JSON(fiber.Map{
+			"error":                  "Two-factor authentication must be enabled before using the API",
+			"twoFactorSetupRequired": true,
+		})
+	}
+
 	c.Locals("userId", user.ID)
 	c.Locals("userEmail", user.Email)
+	c.Locals("sessionId", session.ID)
+	c.Locals("isAdmin", storage.IsAdmin(user))
+	if strings.HasPrefix(c.Get("Authorization"), "Bearer ") {
+		c.Locals("credential", "bearer")
+	} else {
+		c.Locals("credential", "cookie")
+	}
+	return c.Next()
+}
+
+// whatsappError responds with the status matching an error from the
+// whatsapp package: 400 for addresses that can't be parsed, 422 for
+// recipients not on WhatsApp and 500 for everything else.
+func whatsappError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, whatsapp.ErrInvalidAddress), errors.Is(err, whatsapp.ErrInvalidStatus), errors.Is(err, whatsapp.ErrInvalidTimer):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrUnsupportedMedia):
+		return c.Status(415).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrStatusAudience):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrNotOnWhatsApp), errors.Is(err, whatsapp.ErrNotForwardable):
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, whatsapp.ErrMessageNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+func adminMiddleware(c *fiber.Ctx) error {
+	if isAdmin, _ := c.Locals("isAdmin").(bool); !isAdmin {
+		return c.Status(403).JSON(fiber.Map{"error": "Admin access required"})
+	}
 	return c.Next()
 }
 
+func setSessionCookie(c *fiber.Ctx, token string, expiresAt int64) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "wa_token",
+		Value:    token,
+		Path:     "/",
+		HTTPOnly: true,
+		SameSite: "Lax",
+		Expires:  time.UnixMilli(expiresAt),
+	})
+}
+
+// startSession opens a new login session for user and responds with the
+// token, the same shape every auth endpoint returns. action names the audit
+// event (login, registration, password reset) that created the session.
+func startSession(c *fiber.Ctx, user *storage.AuthUser, action string) error {
+	token, session, err := storage.CreateSession(user.ID, c.Get("User-Agent"), c.IP())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	setSessionCookie(c, token, session.ExpiresAt)
+	c.Locals("userEmail", user.Email)
+	c.Locals("credential", "password")
+	auditFor(c, user.ID, action, map[string]interface{}{"sessionId": session.ID})
+	return c.JSON(fiber.Map{"id": user.ID, "email": user.Email, "token": token, "sessionId": session.ID})
+}
+
 func main() {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
+		// Room for video status uploads; Fiber's default is 4 MB
+		BodyLimit: 16 << 20,
 	})
 
 	app.Use(recover.New())
@@ -66,12 +142,14 @@ func main() {
 
 	auth.Get("/me", authMiddleware, func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
-			"id":    c.Locals("userId"),
-			"email": c.Locals("userEmail"),
+			"id":        c.Locals("userId"),
+			"email":     c.Locals("userEmail"),
+			"sessionId": c.Locals("sessionId"),
+			"isAdmin":   c.Locals("isAdmin"),
 		})
 	})
 
-	auth.Post("/register", func(c *fiber.Ctx) error {
+	auth.Post("/register", authRateLimit, func(c *fiber.Ctx) error {
 		type Req struct {
 			Email    string `json:"email"`
 			Password string `json:"password"`
@@ -84,40 +162,81 @@ func main() {
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		c.Cookie(&fiber.Cookie{
-			Name:     "wa_token",
-			Value:    user.Token,
-			Path:     "/",
-			HTTPOnly: true,
-			SameSite: "Lax",
-		})
-		return c.JSON(fiber.Map{"id": user.ID, "email": user.Email, "token": user.Token})
+		return startSession(c, user, "auth.register")
 	})
 
-	auth.Post("/login", func(c *fiber.Ctx) error {
+	auth.Post("/login", authRateLimit, func(c *fiber.Ctx) error {
 		type Req struct {
 			Email    string `json:"email"`
 			Password string `json:"password"`
+			Code     string `json:"code"`
 		}
 		var body Req
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
 		}
+		if wait := loginDelay(c, body.Email); wait > 0 {
+			return tooManyRequests(c, wait, "Too many failed attempts — please wait before trying again")
+		}
 		user, err := storage.Login(body.Email, body.Password)
 		if err != nil {
+			loginFailed(c, body.Email)
+			// A locked account answers like a wrong password so responses
+			// don't reveal which emails have accounts
+			if err == storage.ErrAccountLocked {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid email or password"})
+			}
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		c.Cookie(&fiber.Cookie{
-			Name:     "wa_token",
-			Value:    user.Token,
-			Path:     "/",
-			HTTPOnly: true,
-			SameSite: "Lax",
-		})
-		return c.JSON(fiber.Map{"id": user.ID, "email": user.Email, "token": user.Token})
+		if user.TotpEnabled {
+			userId := user.ID
+			challenge := storage.CreateLoginChallenge(userId)
+			if body.Code == "" {
+				return c.JSON(fiber.Map{"twoFactorRequired": true, "challenge": challenge})
+			}
+			user, err = storage.CompleteLoginChallenge(challenge, body.Code)
+			if err != nil {
+				secondFactorFailed(c, userId, err)
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		loginSucceeded(user)
+		return startSession(c, user, "auth.login")
+	})
+
+	auth.Post("/login/2fa", authRateLimit, func(c *fiber.Ctx) error {
+		type Req struct {
+			Challenge string `json:"challenge"`
+			Code      string `json:"code"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
+		}
+		owner := storage.LoginChallengeOwner(body.Challenge)
+		wait := limiter.retryAfter("ip:" + c.IP())
+		if user := storage.FindUserByID(owner); user != nil {
+			wait = loginDelay(c, user.Email)
+		}
+		if wait > 0 {
+			return tooManyRequests(c, wait, "Too many failed attempts — please wait before trying again")
+		}
+		user, err := storage.CompleteLoginChallenge(body.Challenge, body.Code)
+		if err != nil {
+			secondFactorFailed(c, owner, err)
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		loginSucceeded(user)
+		return startSession(c, user, "auth.login")
 	})
 
 	auth.Post("/logout", func(c *fiber.Ctx) error {
+		token := requestToken(c)
+		if user, session := storage.FindUserBySession(token, c.IP()); user != nil {
+			c.Locals("userEmail", user.Email)
+			auditFor(c, user.ID, "auth.logout", map[string]interface{}{"sessionId": session.ID})
+		}
+		storage.RevokeSessionByToken(token)
 		c.Cookie(&fiber.Cookie{
 			Name:     "wa_token",
 			Value:    "",
@@ -128,7 +247,122 @@ func main() {
 		return c.JSON(fiber.Map{"success": true})
 	})
 
-	auth.Post("/forgot-password", func(c *fiber.Ctx) error {
+	auth.Post("/refresh", authMiddleware, func(c *fiber.Ctx) error {
+		token, session, err := storage.RotateSession(requestToken(c))
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+		}
+		setSessionCookie(c, token, session.ExpiresAt)
+		return c.JSON(fiber.Map{"token": token, "sessionId": session.ID, "expiresAt": time.UnixMilli(session.ExpiresAt).UTC().Format(time.RFC3339)})
+	})
+
+	auth.Get("/sessions", authMiddleware, func(c *fiber.Ctx) error {
+		userId := c.Locals("userId").(string)
+		sessionId := c.Locals("sessionId").(string)
+		return c.JSON(storage.ListSessions(userId, sessionId))
+	})
+
+	auth.Delete("/sessions/:id", authMiddleware, func(c *fiber.Ctx) error {
+		userId := c.Locals("userId").(string)
+		if err := storage.RevokeSession(userId, c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		audit(c, "auth.session_revoked", map[string]interface{}{"sessionId": c.Params("id")})
+		return c.JSON(fiber.Map{"success": true})
+	})
+
+	auth.Delete("/sessions", authMiddleware, func(c *fiber.Ctx) error {
+		userId := c.Locals("userId").(string)
+		except := ""
+		if c.Query("keepCurrent") == "true" {
+			except = c.Locals("sessionId").(string)
+		}
+		revoked := storage.RevokeAllSessions(userId, except)
+		audit(c, "auth.sessions_revoked", map[string]interface{}{"revoked": revoked, "keptCurrent": except != ""})
+		return c.JSON(fiber.Map{"success": true, "revoked": revoked})
+	})
+
+	auth.Get("/2fa", authMiddleware, func(c *fiber.Ctx) error {
+		user := storage.FindUserByEmail(c.Locals("userEmail").(string))
+		if user == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return c.JSON(fiber.Map{
+			"enabled":           user.TotpEnabled,
+			"required":          storage.TwoFactorRequired(user),
+			"recoveryCodesLeft": len(user.RecoveryCodeHashes),
+		})
+	})
+
+	auth.Post("/2fa/setup", authMiddleware, func(c *fiber.Ctx) error {
+		userId := c.Locals("userId").(string)
+		secret, uri, err := storage.BeginTotpSetup(userId)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		qrImage, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{
+			"secret": secret,
+			"uri":    uri,
+			"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrImage),
+		})
+	})
+
+	auth.Post("/2fa/enable", authMiddleware, func(c *fiber.Ctx) error {
+		type Req struct {
+			Code string `json:"code"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
+		}
+		userId := c.Locals("userId").(string)
+		codes, err := storage.EnableTotp(userId, body.Code)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		audit(c, "auth.2fa_enabled", nil)
+		return c.JSON(fiber.Map{"success": true, "recoveryCodes": codes})
+	})
+
+	auth.Post("/2fa/disable", authMiddleware, func(c *fiber.Ctx) error {
+		type Req struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
+		}
+		userId := c.Locals("userId").(string)
+		if err := storage.DisableTotp(userId, body.Password, body.Code); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		audit(c, "auth.2fa_disabled", nil)
+		return c.JSON(fiber.Map{"success": true})
+	})
+
+	auth.Post("/2fa/recovery-codes", authMiddleware, func(c *fiber.Ctx) error {
+		type Req struct {
+			Code string `json:"code"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
+		}
+		userId := c.Locals("userId").(string)
+		codes, err := storage.RegenerateRecoveryCodes(userId, body.Code)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		audit(c, "auth.recovery_codes_regenerated", nil)
+		return c.JSON(fiber.Map{"success": true, "recoveryCodes": codes})
+	})
+
+	auth.Post("/forgot-password", authRateLimit, func(c *fiber.Ctx) error {
 		type Req struct {
 			Email string `json:"email"`
 		}
@@ -136,11 +370,19 @@ func main() {
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
 		}
-		storage.ForgotPassword(body.Email)
+		// Over the per-email limit the request is dropped silently, so the
+		// response never reveals whether the account exists
+		limits := storage.GetGlobalConfig().Config.RateLimits.Effective()
+		if wait, _ := limiter.hit("reset:"+emailKey(body.Email), limits.ResetRequestsPerHour, time.Hour); wait == 0 {
+			storage.ForgotPassword(body.Email)
+			if user := storage.FindUserByEmail(body.Email); user != nil {
+				auditFor(c, user.ID, "auth.password_reset_requested", nil)
+			}
+		}
 		return c.JSON(fiber.Map{"message": "If that email exists, a password reset code has been generated."})
 	})
 
-	auth.Post("/reset-password", func(c *fiber.Ctx) error {
+	auth.Post("/reset-password", authRateLimit, func(c *fiber.Ctx) error {
 		type Req struct {
 			Email       string `json:"email"`
 			Otp         string `json:"otp"`
@@ -154,22 +396,57 @@ func main() {
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		c.Cookie(&fiber.Cookie{
-			Name:     "wa_token",
-			Value:    user.Token,
-			Path:     "/",
-			HTTPOnly: true,
-			SameSite: "Lax",
-		})
-		return c.JSON(fiber.Map{"id": user.ID, "email": user.Email, "token": user.Token})
+		return startSession(c, user, "auth.password_reset")
 	})
 
 	// API Routes
-	api := app.Group("/api", authMiddleware)
+	api := app.Group("/api", authMiddleware, instanceMiddleware)
+
+	admin := api.Group("/admin", adminMiddleware)
+	registerAdminRoutes(admin)
+	registerAuditRoutes(api, admin)
+	registerOrgRoutes(api.Group("/orgs"))
+
+	// Server-sent event stream of everything delivered to webhooks, for
+	// dashboards and bots that can't receive HTTP callbacks
+	api.Get("/events", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		events, stop := whatsapp.Subscribe(instanceId)
+
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer stop()
+			keepAlive := time.NewTicker(25 * time.Second)
+			defer keepAlive.Stop()
+
+			fmt.Fprint(w, ": connected\n\n")
+			if w.Flush() != nil {
+				return
+			}
+			for {
+				select {
+				case ev, ok := <-events:
+					if !ok {
+						return
+					}
+					fmt.Fprintf(w, "data: %s\n\n", ev)
+				case <-keepAlive.C:
+					fmt.Fprint(w, ": ping\n\n")
+				}
+				// A failed flush means the client went away
+				if w.Flush() != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
 
 	api.Get("/status", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		uc := whatsapp.GetUserClient(userId)
+		instanceId := c.Locals("instanceId").(string)
+		uc := whatsapp.GetUserClient(instanceId)
 		return c.JSON(fiber.Map{
 			"status":      uc.ConnectionStatus,
 			"pairingCode": uc.PairingCode,
@@ -180,8 +457,8 @@ func main() {
 	})
 
 	api.Get("/stats", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		userData := storage.LoadUser(userId)
+		instanceId := c.Locals("instanceId").(string)
+		userData := storage.LoadUser(instanceId)
 		return c.JSON(fiber.Map{
 			"messagesSent":     userData.Stats.MessagesSent,
 			"messagesReceived": userData.Stats.MessagesReceived,
@@ -192,8 +469,8 @@ func main() {
 	})
 
 	api.Get("/messages", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		userData := storage.LoadUser(userId)
+		instanceId := c.Locals("instanceId").(string)
+		userData := storage.LoadUser(instanceId)
 
 		limitStr := c.Query("limit", "50")
 		limit, err := strconv.Atoi(limitStr)
@@ -216,21 +493,239 @@ func main() {
 		return c.JSON(reversed)
 	})
 
+	api.Post("/messages/:id/forward", func(c *fiber.Ctx) error {
+		type Req struct {
+			// Phone numbers, JIDs or group IDs
+			To []string `json:"to"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if len(body.To) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "to must list at least one chat"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.ForwardMessage(instanceId, c.Params("id"), body.To)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "message.forwarded", map[string]interface{}{"id": c.Params("id"), "to": body.To})
+		return c.JSON(result)
+	})
+
 	api.Get("/groups", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		groups, err := whatsapp.GetGroups(userId)
+		instanceId := c.Locals("instanceId").(string)
+		groups, err := whatsapp.GetGroups(instanceId)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
 		}
 		return c.JSON(groups)
 	})
 
+	// Registered before /groups/:id so "preview" isn't taken as a group ID
+	api.Get("/groups/preview", func(c *fiber.Ctx) error {
+		invite := c.Query("invite")
+		if invite == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "invite is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		group, err := whatsapp.PreviewInvite(instanceId, invite)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(group)
+	})
+
+	api.Get("/groups/:id", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		group, err := whatsapp.GetGroupDetails(instanceId, c.Params("id"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(group)
+	})
+
+	api.Get("/groups/:id/activity", func(c *fiber.Ctx) error {
+		group, err := whatsapp.ParseGroupJID(c.Params("id"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		limit, err := strconv.Atoi(c.Query("limit", "50"))
+		if err != nil || limit < 1 {
+			limit = 50
+		}
+		// The log is read from disk; no single page reads all of it
+		limit = min(limit, 500)
+		offset, err := strconv.Atoi(c.Query("offset", "0"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		instanceId := c.Locals("instanceId").(string)
+		return c.JSON(storage.GroupActivityLog(instanceId, group.User, limit, offset))
+	})
+
+	api.Get("/groups/:id/invite-link", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.GetInviteLink(instanceId, c.Params("id"), false)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	api.Post("/groups/:id/invite-link/reset", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.GetInviteLink(instanceId, c.Params("id"), true)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.invite_link_reset", map[string]interface{}{"groupId": c.Params("id")})
+		return c.JSON(result)
+	})
+
+	api.Post("/contacts/check", func(c *fiber.Ctx) error {
+		type Req struct {
+			Numbers []string `json:"numbers"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if len(body.Numbers) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "numbers are required"})
+		}
+		if len(body.Numbers) > 500 {
+			return c.Status(400).JSON(fiber.Map{"error": "At most 500 numbers can be checked at once"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.CheckNumbers(instanceId, body.Numbers)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	api.Get("/contacts", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		contacts, err := whatsapp.GetContacts(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(contacts)
+	})
+
+	api.Get("/contacts/:number", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		profile, err := whatsapp.GetContactProfile(instanceId, c.Params("number"))
+		if errors.Is(err, whatsapp.ErrNotOnWhatsApp) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(profile)
+	})
+
+	api.Get("/contacts/:number/picture", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		img, contentType, err := whatsapp.GetProfilePicture(instanceId, c.Params("number"), c.QueryBool("preview"))
+		if errors.Is(err, whatsapp.ErrNotOnWhatsApp) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		if img == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "No profile picture, or it is hidden from you"})
+		}
+		if contentType == "" {
+			contentType = "image/jpeg"
+		}
+		c.Set("Content-Type", contentType)
+		c.Set("Cache-Control", "private, max-age=3600")
+		return c.Send(img)
+	})
+
+	api.Get("/blocklist", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		blocked, err := whatsapp.GetBlocklist(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(blocked)
+	})
+
+	api.Post("/blocklist", func(c *fiber.Ctx) error {
+		type Req struct {
+			Number string `json:"number"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetBlocked(instanceId, body.Number, true)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "contact.blocked", map[string]interface{}{"number": body.Number})
+		return c.JSON(result)
+	})
+
+	api.Delete("/blocklist/:number", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetBlocked(instanceId, c.Params("number"), false)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "contact.unblocked", map[string]interface{}{"number": c.Params("number")})
+		return c.JSON(result)
+	})
+
+	api.Get("/ignored", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		return c.JSON(storage.GetIgnoredSenders(instanceId))
+	})
+
+	api.Post("/ignored", func(c *fiber.Ctx) error {
+		type Req struct {
+			Number string `json:"number"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		jid, err := whatsapp.ParseUserJID(body.Number)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		instanceId := c.Locals("instanceId").(string)
+		if storage.IgnoreSender(instanceId, jid.User) {
+			audit(c, "contact.ignored", map[string]interface{}{"number": jid.User})
+		}
+		return c.JSON(fiber.Map{"success": true, "ignored": storage.GetIgnoredSenders(instanceId)})
+	})
+
+	api.Delete("/ignored/:number", func(c *fiber.Ctx) error {
+		jid, err := whatsapp.ParseUserJID(c.Params("number"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		instanceId := c.Locals("instanceId").(string)
+		if !storage.UnignoreSender(instanceId, jid.User) {
+			return c.Status(404).JSON(fiber.Map{"error": "Number is not on the ignore list"})
+		}
+		audit(c, "contact.unignored", map[string]interface{}{"number": jid.User})
+		return c.JSON(fiber.Map{"success": true, "ignored": storage.GetIgnoredSenders(instanceId)})
+	})
+
 	api.Get("/hooks", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		return c.JSON(storage.GetWebhooks(userId))
+		instanceId := c.Locals("instanceId").(string)
+		return c.JSON(storage.GetWebhooks(instanceId))
 	})
 
-	api.Post("/hooks/register", func(c *fiber.Ctx) error {
+	api.Post("/hooks/register", requireOrgRole(storage.OrgRoleAdmin), func(c *fiber.Ctx) error {
 		type Req struct {
 			URL  string `json:"url"`
 			Name string `json:"name"`
@@ -243,7 +738,7 @@ func main() {
 			return c.Status(400).JSON(fiber.Map{"error": "URL is required"})
 		}
 
-		userId := c.Locals("userId").(string)
+		instanceId := c.Locals("instanceId").(string)
 
 		// Generate a simple ID
 		hookId := fmt.Sprintf("hook_%d", time.Now().UnixNano())
@@ -254,11 +749,12 @@ func main() {
 			"name": body.Name,
 		}
 
-		storage.RegisterWebhook(userId, hook)
+		storage.RegisterWebhook(instanceId, hook)
+		audit(c, "webhook.registered", hook)
 		return c.JSON(hook)
 	})
 
-	api.Delete("/hooks/unregister", func(c *fiber.Ctx) error {
+	api.Delete("/hooks/unregister", requireOrgRole(storage.OrgRoleAdmin), func(c *fiber.Ctx) error {
 		type Req struct {
 			ID string `json:"id"`
 		}
@@ -266,15 +762,20 @@ func main() {
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid format"})
 		}
-		userId := c.Locals("userId").(string)
-		storage.UnregisterWebhook(userId, body.ID)
+		instanceId := c.Locals("instanceId").(string)
+		storage.UnregisterWebhook(instanceId, body.ID)
+		audit(c, "webhook.removed", map[string]interface{}{"id": body.ID})
 		return c.JSON(fiber.Map{"success": true, "message": "Webhook removed"})
 	})
 
 	api.Post("/send-message", func(c *fiber.Ctx) error {
 		type Req struct {
-			Number  string `json:"number"`
-			Message string `json:"message"`
+			Number    string  `json:"number"`
+			Message   string  `json:"message"`
+			Typing    bool    `json:"typing"`
+			Ephemeral *uint32 `json:"ephemeral"`
+			// Defaults to true
+			LinkPreview *bool `json:"linkPreview"`
 		}
 		var body Req
 		if err := c.BodyParser(&body); err != nil {
@@ -284,18 +785,28 @@ func main() {
 			return c.Status(400).JSON(fiber.Map{"error": "number and message are required"})
 		}
 
-		userId := c.Locals("userId").(string)
-		result, err := whatsapp.SendMessage(userId, body.Number, body.Message)
+		instanceId := c.Locals("instanceId").(string)
+		opts := whatsapp.SendOptions{
+			Typing:        body.Typing,
+			Ephemeral:     body.Ephemeral,
+			NoLinkPreview: body.LinkPreview != nil && !*body.LinkPreview,
+		}
+		result, err := whatsapp.SendMessage(instanceId, body.Number, body.Message, opts)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
 		}
+		audit(c, "message.sent", map[string]interface{}{"to": body.Number})
 		return c.JSON(fiber.Map{"success": true, "message": result})
 	})
 
 	api.Post("/send-group-message", func(c *fiber.Ctx) error {
 		type Req struct {
-			GroupId string `json:"groupId"`
-			Message string `json:"message"`
+			GroupId   string  `json:"groupId"`
+			Message   string  `json:"message"`
+			Typing    bool    `json:"typing"`
+			Ephemeral *uint32 `json:"ephemeral"`
+			// Defaults to true
+			LinkPreview *bool `json:"linkPreview"`
 		}
 		var body Req
 		if err := c.BodyParser(&body); err != nil {
@@ -305,27 +816,487 @@ func main() {
 			return c.Status(400).JSON(fiber.Map{"error": "groupId and message are required"})
 		}
 
-		userId := c.Locals("userId").(string)
-		result, err := whatsapp.SendGroupMessage(userId, body.GroupId, body.Message)
+		instanceId := c.Locals("instanceId").(string)
+		opts := whatsapp.SendOptions{
+			Typing:        body.Typing,
+			Ephemeral:     body.Ephemeral,
+			NoLinkPreview: body.LinkPreview != nil && !*body.LinkPreview,
+		}
+		result, err := whatsapp.SendGroupMessage(instanceId, body.GroupId, body.Message, opts)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
 		}
+		audit(c, "message.sent", map[string]interface{}{"to": body.GroupId, "isGroup": true})
 		return c.JSON(fiber.Map{"success": true, "message": result})
 	})
 
-	api.Post("/join-group", func(c *fiber.Ctx) error {
+	api.Put("/presence", func(c *fiber.Ctx) error {
 		type Req struct {
-			InviteLink string `json:"inviteLink"`
+			Available *bool `json:"available"`
 		}
 		var body Req
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 		}
-		userId := c.Locals("userId").(string)
-		result, err := whatsapp.JoinGroup(userId, body.InviteLink)
+		if body.Available == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "available is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		if err := whatsapp.SetPresence(instanceId, *body.Available); err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(fiber.Map{"success": true, "available": *body.Available})
+	})
+
+	api.Post("/presence/subscribe", func(c *fiber.Ctx) error {
+		type Req struct {
+			Number string `json:"number"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		if err := whatsapp.SubscribePresence(instanceId, body.Number); err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(fiber.Map{"success": true})
+	})
+
+	api.Get("/chats", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		chats := storage.ListChats(instanceId)
+		if archived := c.Query("archived"); archived != "" {
+			want := archived == "true"
+			filtered := make([]map[string]interface{}, 0, len(chats))
+			for _, chat := range chats {
+				if chat["archived"] == want {
+					filtered = append(filtered, chat)
+				}
+			}
+			chats = filtered
+		}
+		return c.JSON(chats)
+	})
+
+	api.Post("/chats/:chat/read", func(c *fiber.Ctx) error {
+		type Req struct {
+			MessageIds []string `json:"messageIds"`
+		}
+		var body Req
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+			}
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.MarkChatRead(instanceId, c.Params("chat"), body.MessageIds)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/unread", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.MarkChatUnread(instanceId, c.Params("chat"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/:action<regex(^(un)?(archive|pin)$)>", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		action := c.Params("action")
+		enable := !strings.HasPrefix(action, "un")
+
+		var result interface{}
+		var err error
+		if strings.HasSuffix(action, "archive") {
+			result, err = whatsapp.SetChatArchived(instanceId, c.Params("chat"), enable)
+		} else {
+			result, err = whatsapp.SetChatPinned(instanceId, c.Params("chat"), enable)
+		}
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat."+action, map[string]interface{}{"chat": c.Params("chat")})
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/mute", func(c *fiber.Ctx) error {
+		type Req struct {
+			// Duration in seconds; omitted or 0 mutes forever
+			Duration int64 `json:"duration"`
+		}
+		var body Req
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+			}
+		}
+		if body.Duration < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "duration must be a positive number of seconds"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetChatMuted(instanceId, c.Params("chat"), true, time.Duration(body.Duration)*time.Second)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat.mute", map[string]interface{}{"chat": c.Params("chat"), "duration": body.Duration})
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/unmute", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetChatMuted(instanceId, c.Params("chat"), false, 0)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat.unmute", map[string]interface{}{"chat": c.Params("chat")})
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/clear", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.ClearChat(instanceId, c.Params("chat"), c.QueryBool("deleteMedia"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat.cleared", map[string]interface{}{"chat": c.Params("chat")})
+		return c.JSON(result)
+	})
+
+	api.Delete("/chats/:chat", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.DeleteChat(instanceId, c.Params("chat"), c.QueryBool("deleteMedia"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat.deleted", map[string]interface{}{"chat": c.Params("chat")})
+		return c.JSON(result)
+	})
+
+	api.Post("/chats/:chat/state", func(c *fiber.Ctx) error {
+		type Req struct {
+			State string `json:"state"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		switch body.State {
+		case "composing", "recording", "paused":
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "state must be composing, recording or paused"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		if err := whatsapp.SendChatState(instanceId, c.Params("chat"), body.State); err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(fiber.Map{"success": true})
+	})
+
+	api.Get("/statuses", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		limit, err := strconv.Atoi(c.Query("limit", "100"))
+		if err != nil || limit < 1 {
+			limit = 100
+		}
+		from := ""
+		if number := c.Query("from"); number != "" {
+			jid, err := whatsapp.ParseUserJID(number)
+			if err != nil {
+				return whatsappError(c, err)
+			}
+			from = jid.String()
+		}
+		return c.JSON(storage.RecentStatuses(instanceId, from, limit))
+	})
+
+	api.Get("/statuses/audience", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.GetStatusAudience(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(result)
+	})
+
+	api.Post("/statuses", func(c *fiber.Ctx) error {
+		type Req struct {
+			Text            string `json:"text" form:"text"`
+			BackgroundColor string `json:"backgroundColor" form:"backgroundColor"`
+			Font            *int32 `json:"font" form:"font"`
+			Caption         string `json:"caption" form:"caption"`
+			Audience        string `json:"audience" form:"audience"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		post := whatsapp.StatusPost{
+			Text:            body.Text,
+			BackgroundColor: body.BackgroundColor,
+			Font:            body.Font,
+			Caption:         body.Caption,
+			Audience:        body.Audience,
+		}
+
+		// Image and video statuses are uploaded as multipart field "file"
+		if file, err := c.FormFile("file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+			}
+			post.Media, err = io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+			}
+			post.Mimetype = file.Header.Get("Content-Type")
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.PostStatus(instanceId, post)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "status.posted", map[string]interface{}{"audience": body.Audience})
+		return c.JSON(result)
+	})
+
+	api.Get("/channels", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		channels, err := whatsapp.GetChannels(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(channels)
+	})
+
+	api.Post("/channels", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name        string `json:"name" form:"name"`
+			Description string `json:"description" form:"description"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		if strings.TrimSpace(body.Name) == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+
+		var picture []byte
+		if file, err := c.FormFile("picture"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read picture"})
+			}
+			picture, err = io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read picture"})
+			}
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		channel, err := whatsapp.CreateChannel(instanceId, body.Name, body.Description, picture)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "channel.created", map[string]interface{}{"name": body.Name})
+		return c.JSON(channel)
+	})
+
+	api.Post("/channels/:action<regex(^(follow|unfollow)$)>", func(c *fiber.Ctx) error {
+		type Req struct {
+			// Channel ID or whatsapp.com/channel/… invite link
+			Channel string `json:"channel"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Channel == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "channel is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetChannelFollowed(instanceId, body.Channel, c.Params("action") == "follow")
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "channel."+c.Params("action"), map[string]interface{}{"channel": body.Channel})
+		return c.JSON(result)
+	})
+
+	api.Get("/channels/:id", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		channel, err := whatsapp.GetChannel(instanceId, c.Params("id"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(channel)
+	})
+
+	api.Get("/channels/:id/posts", func(c *fiber.Ctx) error {
+		count, err := strconv.Atoi(c.Query("count", "20"))
+		if err != nil || count < 1 || count > 100 {
+			count = 20
+		}
+		before, err := strconv.Atoi(c.Query("before", "0"))
+		if err != nil || before < 0 {
+			before = 0
+		}
+		instanceId := c.Locals("instanceId").(string)
+		posts, err := whatsapp.GetChannelPosts(instanceId, c.Params("id"), count, before)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(posts)
+	})
+
+	api.Post("/channels/:id/posts", func(c *fiber.Ctx) error {
+		type Req struct {
+			Text string `json:"text" form:"text"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		// Image and video posts are uploaded as multipart field "file";
+		// text becomes the caption
+		var media []byte
+		var mimetype string
+		if file, err := c.FormFile("file"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+			}
+			media, err = io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+			}
+			mimetype = file.Header.Get("Content-Type")
+		}
+		if body.Text == "" && len(media) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "text or file is required"})
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.PostToChannel(instanceId, c.Params("id"), body.Text, media, mimetype)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "channel.posted", map[string]interface{}{"channel": c.Params("id")})
+		return c.JSON(result)
+	})
+
+	api.Get("/calls", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		limit, err := strconv.Atoi(c.Query("limit", "50"))
+		if err != nil || limit < 1 {
+			limit = 50
+		}
+		return c.JSON(storage.GetCalls(instanceId, limit))
+	})
+
+	api.Get("/calls/settings", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		return c.JSON(storage.GetCallPolicy(instanceId))
+	})
+
+	api.Put("/calls/settings", requireOrgRole(storage.OrgRoleAdmin), func(c *fiber.Ctx) error {
+		var policy storage.CallPolicy
+		if err := c.BodyParser(&policy); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		policy.RejectMessage = strings.TrimSpace(policy.RejectMessage)
+		if len(policy.RejectMessage) > 1000 {
+			return c.Status(400).JSON(fiber.Map{"error": "rejectMessage must be at most 1000 characters"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		storage.SetCallPolicy(instanceId, policy)
+		audit(c, "calls.settings_updated", map[string]interface{}{"autoReject": policy.AutoReject, "rejectMessage": policy.RejectMessage != ""})
+		return c.JSON(policy)
+	})
+
+	api.Post("/send-media", func(c *fiber.Ctx) error {
+		type Req struct {
+			// Phone number, JID or group ID
+			To        string  `form:"to"`
+			Caption   string  `form:"caption"`
+			ViewOnce  bool    `form:"viewOnce"`
+			Typing    bool    `form:"typing"`
+			Ephemeral *uint32 `form:"ephemeral"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		file, err := c.FormFile("file")
+		if err != nil || body.To == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "to and file are required"})
+		}
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Could not read file"})
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		opts := whatsapp.SendOptions{Typing: body.Typing, Ephemeral: body.Ephemeral, ViewOnce: body.ViewOnce}
+		result, err := whatsapp.SendMedia(instanceId, body.To, data, file.Header.Get("Content-Type"), body.Caption, opts)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "message.sent", map[string]interface{}{"to": body.To, "media": true, "viewOnce": body.ViewOnce})
+		return c.JSON(fiber.Map{"success": true, "message": result})
+	})
+
+	api.Put("/chats/:chat/disappearing", func(c *fiber.Ctx) error {
+		type Req struct {
+			// Seconds: 0, 86400, 604800 or 7776000
+			Timer *uint32 `json:"timer"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Timer == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "timer is required"})
 		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetDisappearingTimer(instanceId, c.Params("chat"), *body.Timer)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "chat.disappearing_timer", map[string]interface{}{"chat": c.Params("chat"), "timer": *body.Timer})
+		return c.JSON(result)
+	})
+
+	api.Post("/join-group", func(c *fiber.Ctx) error {
+		type Req struct {
+			InviteLink string `json:"inviteLink"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.JoinGroup(instanceId, body.InviteLink)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.joined", map[string]interface{}{"inviteLink": body.InviteLink})
 		return c.JSON(fiber.Map{"success": true, "result": result})
 	})
 
@@ -337,11 +1308,12 @@ func main() {
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 		}
-		userId := c.Locals("userId").(string)
-		result, err := whatsapp.LeaveGroup(userId, body.GroupId)
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.LeaveGroup(instanceId, body.GroupId)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
 		}
+		audit(c, "group.left", map[string]interface{}{"groupId": body.GroupId})
 		return c.JSON(fiber.Map{"success": true, "result": result})
 	})
 
@@ -354,24 +1326,300 @@ func main() {
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 		}
-		userId := c.Locals("userId").(string)
-		result, err := whatsapp.AddToGroup(userId, body.GroupId, body.Participants)
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.AddToGroup(instanceId, body.GroupId, body.Participants)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
 		}
+		audit(c, "group.participants_added", map[string]interface{}{"groupId": body.GroupId, "participants": body.Participants})
 		return c.JSON(fiber.Map{"success": true, "result": result})
 	})
 
-	api.Post("/disconnect", func(c *fiber.Ctx) error {
-		userId := c.Locals("userId").(string)
-		err := whatsapp.Disconnect(userId)
+	api.Post("/groups", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name         string   `json:"name"`
+			Participants []string `json:"participants"`
+			CommunityID  string   `json:"communityId"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.CreateGroup(instanceId, body.Name, body.Participants, body.CommunityID)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return whatsappError(c, err)
+		}
+		audit(c, "group.created", map[string]interface{}{"name": body.Name, "participants": body.Participants, "communityId": body.CommunityID})
+		return c.JSON(fiber.Map{"success": true, "group": result})
+	})
+
+	api.Put("/groups/:id/name", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name string `json:"name"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetGroupName(instanceId, c.Params("id"), body.Name)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.renamed", map[string]interface{}{"groupId": c.Params("id"), "name": body.Name})
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Put("/groups/:id/description", func(c *fiber.Ctx) error {
+		type Req struct {
+			Description string `json:"description"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetGroupDescription(instanceId, c.Params("id"), body.Description)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.description_changed", map[string]interface{}{"groupId": c.Params("id")})
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Delete("/groups/:id/description", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetGroupDescription(instanceId, c.Params("id"), "")
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.description_changed", map[string]interface{}{"groupId": c.Params("id"), "cleared": true})
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Put("/groups/:id/photo", func(c *fiber.Ctx) error {
+		file, err := c.FormFile("image")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "image file is required"})
+		}
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer f.Close()
+		img, err := io.ReadAll(f)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetGroupPhoto(instanceId, c.Params("id"), img)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.photo_changed", map[string]interface{}{"groupId": c.Params("id")})
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Delete("/groups/:id/photo", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SetGroupPhoto(instanceId, c.Params("id"), nil)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.photo_changed", map[string]interface{}{"groupId": c.Params("id"), "removed": true})
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Put("/groups/:id/settings", func(c *fiber.Ctx) error {
+		type Req struct {
+			Announce *bool `json:"announce"`
+			Locked   *bool `json:"locked"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Announce == nil && body.Locked == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "announce or locked is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.UpdateGroupSettings(instanceId, c.Params("id"), body.Announce, body.Locked)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.settings_changed", result.(map[string]interface{}))
+		return c.JSON(fiber.Map{"success": true, "result": result})
+	})
+
+	api.Post("/groups/:id/participants/:action", func(c *fiber.Ctx) error {
+		type Req struct {
+			Participants []string `json:"participants"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if len(body.Participants) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "participants are required"})
+		}
+		action := c.Params("action")
+		switch action {
+		case "add", "remove", "promote", "demote":
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "action must be add, remove, promote or demote"})
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		outcomes, err := whatsapp.UpdateParticipants(instanceId, c.Params("id"), body.Participants, action)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.participants_"+action, map[string]interface{}{"groupId": c.Params("id"), "participants": outcomes})
+		return c.JSON(fiber.Map{"success": true, "participants": outcomes})
+	})
+
+	api.Get("/groups/:id/requests", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		requests, err := whatsapp.GetJoinRequests(instanceId, c.Params("id"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(requests)
+	})
+
+	api.Post("/groups/:id/requests/:decision", func(c *fiber.Ctx) error {
+		type Req struct {
+			Participants []string `json:"participants"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		decision := c.Params("decision")
+		if decision != "approve" && decision != "reject" {
+			return c.Status(400).JSON(fiber.Map{"error": "decision must be approve or reject"})
+		}
+		if len(body.Participants) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "participants are required"})
+		}
+
+		instanceId := c.Locals("instanceId").(string)
+		outcomes, err := whatsapp.ReviewJoinRequests(instanceId, c.Params("id"), body.Participants, decision == "approve")
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "group.requests_"+decision+"d", map[string]interface{}{"groupId": c.Params("id"), "participants": outcomes})
+		return c.JSON(fiber.Map{"success": true, "participants": outcomes})
+	})
+
+	api.Get("/communities", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		communities, err := whatsapp.GetCommunities(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(communities)
+	})
+
+	api.Post("/communities", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 		}
+		if body.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.CreateCommunity(instanceId, body.Name, body.Description)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "community.created", map[string]interface{}{"name": body.Name})
+		return c.JSON(fiber.Map{"success": true, "community": result})
+	})
+
+	api.Get("/communities/:id/groups", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		groups, err := whatsapp.GetCommunityGroups(instanceId, c.Params("id"))
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		return c.JSON(groups)
+	})
+
+	api.Post("/communities/:id/groups", func(c *fiber.Ctx) error {
+		type Req struct {
+			GroupId string `json:"groupId"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.GroupId == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "groupId is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.LinkCommunityGroup(instanceId, c.Params("id"), body.GroupId, true)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "community.group_linked", map[string]interface{}{"communityId": c.Params("id"), "groupId": body.GroupId})
+		return c.JSON(result)
+	})
+
+	api.Delete("/communities/:id/groups/:groupId", func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.LinkCommunityGroup(instanceId, c.Params("id"), c.Params("groupId"), false)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "community.group_unlinked", map[string]interface{}{"communityId": c.Params("id"), "groupId": c.Params("groupId")})
+		return c.JSON(result)
+	})
+
+	api.Post("/communities/:id/announce", func(c *fiber.Ctx) error {
+		type Req struct {
+			Message string `json:"message"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		if body.Message == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "message is required"})
+		}
+		instanceId := c.Locals("instanceId").(string)
+		result, err := whatsapp.SendCommunityAnnouncement(instanceId, c.Params("id"), body.Message)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "message.sent", map[string]interface{}{"to": c.Params("id"), "isGroup": true, "announcement": true})
+		return c.JSON(fiber.Map{"success": true, "message": result})
+	})
+
+	api.Post("/disconnect", requireOrgRole(storage.OrgRoleAdmin), func(c *fiber.Ctx) error {
+		instanceId := c.Locals("instanceId").(string)
+		err := whatsapp.Disconnect(instanceId)
+		if err != nil {
+			return whatsappError(c, err)
+		}
+		audit(c, "whatsapp.disconnected", nil)
 		return c.JSON(fiber.Map{"success": true, "message": "WhatsApp disconnected"})
 	})
 
-	api.Post("/reconnect", func(c *fiber.Ctx) error {
+	api.Post("/reconnect", requireOrgRole(storage.OrgRoleAdmin), func(c *fiber.Ctx) error {
 		type Req struct {
 			Method      string `json:"method"`
 			PhoneNumber string `json:"phoneNumber"`
@@ -386,25 +1634,34 @@ func main() {
 			method = body.Method
 		}
 
-		userId := c.Locals("userId").(string)
+		instanceId := c.Locals("instanceId").(string)
+		audit(c, "whatsapp.reconnect", map[string]interface{}{"method": method})
 
 		// Don't await initialization in the handler
 		go func() {
-			err := whatsapp.Initialize(userId, method, body.PhoneNumber)
+			err := whatsapp.Initialize(instanceId, method, body.PhoneNumber)
 			if err != nil {
-				log.Printf("Reconnect error for user %s: %v\n", userId, err)
+				log.Printf("Reconnect error for user %s: %v\n", instanceId, err)
 			}
 		}()
 
 		return c.JSON(fiber.Map{"success": true, "message": "Reconnecting via " + method + "..."})
 	})
 
+	storage.EnsureAdmin()
 	config := storage.GetGlobalConfig()
+
+	notifier, err := notify.FromConfig(config.Config)
+	if err != nil {
+		log.Fatalf("Invalid OTP delivery config: %v", err)
+	}
+	storage.SetOtpNotifier(notifier)
+
 	port := config.Config.Port
 	if port == 0 {
 		port = 3000
 	}
-	
+
 	if envPort := os.Getenv("PORT"); envPort != "" {
 		if p, err := strconv.Atoi(envPort); err == nil {
 			port = p
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"wa-server-go/storage"
+)
+
+// TestMain runs the tests from a temporary directory, so storage's relative
+// data paths point at a scratch copy instead of the working tree.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wa-server-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.MkdirAll("data/users", 0755)
+	storage.EnsureGlobal()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
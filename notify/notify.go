@@ -0,0 +1,26 @@
+// Package notify delivers password-reset codes through the channel chosen
+// in the global config.
+package notify
+
+import (
+	"fmt"
+
+	"wa-server-go/storage"
+)
+
+// FromConfig returns the notifier selected by config.OtpDelivery.
+func FromConfig(config storage.ServerConfig) (storage.OtpNotifier, error) {
+	switch config.OtpDelivery {
+	case "", "stdout":
+		return storage.StdoutNotifier{}, nil
+	case "smtp":
+		return NewSMTPNotifier(config.SMTP, config.BotName)
+	case "whatsapp":
+		if config.OtpWhatsAppSender == "" {
+			return nil, fmt.Errorf("otpWhatsAppSender must be set to deliver codes over WhatsApp")
+		}
+		return WhatsAppNotifier{SenderUserID: config.OtpWhatsAppSender, BotName: config.BotName}, nil
+	default:
+		return nil, fmt.Errorf("unknown otpDelivery %q", config.OtpDelivery)
+	}
+}
@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"wa-server-go/storage"
+)
+
+const defaultTextTemplate = `Hi,
+
+Your {{.BotName}} password reset code is: {{.Code}}
+
+It is valid for {{.ValidMinutes}} minutes. If you didn't request a reset, you can ignore this email.
+`
+
+const defaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #222;">
+  <p>Hi,</p>
+  <p>Your {{.BotName}} password reset code is:</p>
+  <p style="font-size: 28px; font-weight: bold; letter-spacing: 4px;">{{.Code}}</p>
+  <p>It is valid for {{.ValidMinutes}} minutes. If you didn't request a reset, you can ignore this email.</p>
+</body>
+</html>
+`
+
+// OtpTemplateData is passed to the SMTP text and HTML templates.
+type OtpTemplateData struct {
+	BotName      string
+	Email        string
+	Code         string
+	ValidMinutes int
+}
+
+// SMTPNotifier emails password-reset codes as multipart text/HTML messages.
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+	Subject  string
+	BotName  string
+	Timeout  time.Duration
+
+	// TLSConfig overrides the TLS settings used for "tls" and "starttls",
+	// e.g. to trust a local test server's certificate.
+	TLSConfig *tls.Config
+
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// NewSMTPNotifier builds a notifier from the global SMTP config. Template
+// fields are file paths; the built-in templates are used when they're empty.
+func NewSMTPNotifier(conf storage.SMTPConfig, botName string) (*SMTPNotifier, error) {
+	if conf.Host == "" || conf.From == "" {
+		return nil, fmt.Errorf("smtp host and from address are required")
+	}
+	n := &SMTPNotifier{
+		Host:     conf.Host,
+		Port:     conf.Port,
+		Username: conf.Username,
+		Password: conf.Password,
+		From:     conf.From,
+		TLS:      strings.ToLower(conf.TLS),
+		Subject:  conf.Subject,
+		BotName:  botName,
+		Timeout:  15 * time.Second,
+	}
+	if n.TLS == "" {
+		n.TLS = "starttls"
+	}
+	if n.Port == 0 {
+		switch n.TLS {
+		case "tls":
+			n.Port = 465
+		default:
+			n.Port = 587
+		}
+	}
+	if n.Subject == "" {
+		n.Subject = "Your password reset code"
+	}
+
+	textSrc, err := readTemplate(conf.TextTemplate, defaultTextTemplate)
+	if err != nil {
+		return nil, err
+	}
+	htmlSrc, err := readTemplate(conf.HTMLTemplate, defaultHTMLTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if n.text, err = texttemplate.New("text").Parse(textSrc); err != nil {
+		return nil, fmt.Errorf("parse text template: %w", err)
+	}
+	if n.html, err = htmltemplate.New("html").Parse(htmlSrc); err != nil {
+		return nil, fmt.Errorf("parse html template: %w", err)
+	}
+	return n, nil
+}
+
+func readTemplate(path string, fallback string) (string, error) {
+	if path == "" {
+		return fallback, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read template %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+func (n *SMTPNotifier) SendResetOtp(to storage.OtpRecipient, otp string, expires time.Time) error {
+	data := OtpTemplateData{
+		BotName:      n.BotName,
+		Email:        to.Email,
+		Code:         otp,
+		ValidMinutes: int(time.Until(expires).Round(time.Minute).Minutes()),
+	}
+	msg, err := n.buildMessage(to.Email, data)
+	if err != nil {
+		return err
+	}
+	return n.send(to.Email, msg)
+}
+
+func (n *SMTPNotifier) buildMessage(to string, data OtpTemplateData) ([]byte, error) {
+	var text, html bytes.Buffer
+	if err := n.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("render text template: %w", err)
+	}
+	if err := n.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("render html template: %w", err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", text.Bytes()},
+		{"text/html; charset=UTF-8", html.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	mw.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", n.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func (n *SMTPNotifier) tlsConfig() *tls.Config {
+	if n.TLSConfig != nil {
+		return n.TLSConfig
+	}
+	return &tls.Config{ServerName: n.Host}
+}
+
+func (n *SMTPNotifier) send(to string, msg []byte) error {
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	dialer := &net.Dialer{Timeout: n.Timeout}
+
+	var conn net.Conn
+	var err error
+	if n.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, n.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(n.Timeout))
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer c.Close()
+
+	if n.TLS == "starttls" {
+		if err := c.StartTLS(n.tlsConfig()); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	sender := n.From
+	if addr, err := mail.ParseAddress(n.From); err == nil {
+		sender = addr.Address
+	}
+	if err := c.Mail(sender); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return c.Quit()
+}
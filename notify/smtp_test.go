@@ -0,0 +1,317 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wa-server-go/storage"
+)
+
+// receivedMail is what the fake SMTP server saw for one delivery.
+type receivedMail struct {
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// fakeSMTP is a minimal SMTP server on a local port that records each
+// delivery. Recipients in reject are refused with a 550.
+type fakeSMTP struct {
+	ln     net.Listener
+	reject map[string]bool
+	mails  chan receivedMail
+}
+
+func startFakeSMTP(t *testing.T, tlsConfig *tls.Config) *fakeSMTP {
+	t.Helper()
+	var ln net.Listener
+	var err error
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, reject: make(map[string]bool), mails: make(chan receivedMail, 1)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := textproto.NewReader(bufio.NewReader(conn))
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var mail receivedMail
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			mail.auth = string(decoded)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if s.reject[rcpt] {
+				reply("550 No such user")
+				continue
+			}
+			mail.to = append(mail.to, rcpt)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			if mail.data, err = r.ReadDotBytes(); err != nil {
+				return
+			}
+			reply("250 OK")
+			s.mails <- mail
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) received(t *testing.T) receivedMail {
+	t.Helper()
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was delivered")
+		return receivedMail{}
+	}
+}
+
+// mailParts parses a delivered message and returns its headers and the
+// decoded body of each part, keyed by content type.
+func mailParts(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return msg.Header, parts
+}
+
+func TestSMTPNotifierDelivers(t *testing.T) {
+	server := startFakeSMTP(t, nil)
+	n, err := NewSMTPNotifier(storage.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "mailer",
+		Password: "secret",
+		From:     "Bot <bot@example.com>",
+		TLS:      "none",
+	}, "Test Bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := storage.OtpRecipient{UserID: "u1", Email: "user@example.com"}
+	if err := n.SendResetOtp(to, "123456", time.Now().Add(15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := server.received(t)
+	if m.auth != "\x00mailer\x00secret" {
+		t.Errorf("AUTH PLAIN credentials = %q", m.auth)
+	}
+	if m.from != "bot@example.com" {
+		t.Errorf("MAIL FROM = %q, want the bare sender address", m.from)
+	}
+	if len(m.to) != 1 || m.to[0] != "user@example.com" {
+		t.Errorf("RCPT TO = %v", m.to)
+	}
+
+	header, parts := mailParts(t, m.data)
+	if got := header.Get("From"); got != "Bot <bot@example.com>" {
+		t.Errorf("From = %q", got)
+	}
+	if got := header.Get("To"); got != "user@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := header.Get("Subject"); got != "Your password reset code" {
+		t.Errorf("Subject = %q", got)
+	}
+	text, html := parts["text/plain"], parts["text/html"]
+	for _, want := range []string{"123456", "Test Bot", "15 minutes"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part doesn't contain %q:\n%s", want, text)
+		}
+	}
+	if !strings.Contains(html, "123456") || !strings.Contains(html, "<html>") {
+		t.Errorf("html part doesn't contain the code:\n%s", html)
+	}
+}
+
+func TestSMTPNotifierCustomTemplates(t *testing.T) {
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "reset.txt")
+	htmlPath := filepath.Join(dir, "reset.html")
+	os.WriteFile(textPath, []byte("Code for {{.Email}}: {{.Code}}"), 0644)
+	os.WriteFile(htmlPath, []byte("<p>{{.Code}} & {{.BotName}}</p>"), 0644)
+
+	server := startFakeSMTP(t, nil)
+	n, err := NewSMTPNotifier(storage.SMTPConfig{
+		Host:         "127.0.0.1",
+		Port:         server.port(),
+		From:         "bot@example.com",
+		TLS:          "none",
+		Subject:      "Réinitialisation",
+		TextTemplate: textPath,
+		HTMLTemplate: htmlPath,
+	}, "<Bot>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SendResetOtp(storage.OtpRecipient{Email: "user@example.com"}, "654321", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	header, parts := mailParts(t, server.received(t).data)
+	if subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject")); err != nil || subject != "Réinitialisation" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if got := parts["text/plain"]; got != "Code for user@example.com: 654321" {
+		t.Errorf("text part = %q", got)
+	}
+	// The HTML template escapes its data
+	if got := parts["text/html"]; got != "<p>654321 & &lt;Bot&gt;</p>" {
+		t.Errorf("html part = %q", got)
+	}
+}
+
+func TestSMTPNotifierImplicitTLS(t *testing.T) {
+	// Borrow httptest's certificate, which is valid for 127.0.0.1
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certServer.Close()
+	server := startFakeSMTP(t, &tls.Config{Certificates: certServer.TLS.Certificates})
+
+	n, err := NewSMTPNotifier(storage.SMTPConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "bot@example.com",
+		TLS:  "tls",
+	}, "Test Bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := storage.OtpRecipient{Email: "user@example.com"}
+
+	if err := n.SendResetOtp(to, "111111", time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("delivered over TLS to a server with an untrusted certificate")
+	}
+
+	n.TLSConfig = certServer.Client().Transport.(*http.Transport).TLSClientConfig
+	if err := n.SendResetOtp(to, "111111", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if m := server.received(t); len(m.to) != 1 || m.to[0] != "user@example.com" {
+		t.Errorf("RCPT TO = %v", m.to)
+	}
+}
+
+func TestSMTPNotifierRejectedRecipient(t *testing.T) {
+	server := startFakeSMTP(t, nil)
+	server.reject["gone@example.com"] = true
+	n, err := NewSMTPNotifier(storage.SMTPConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "bot@example.com",
+		TLS:  "none",
+	}, "Test Bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.SendResetOtp(storage.OtpRecipient{Email: "gone@example.com"}, "123456", time.Now().Add(time.Minute))
+	if err == nil || !strings.Contains(err.Error(), "rcpt") {
+		t.Errorf("err = %v, want a rcpt to error", err)
+	}
+}
+
+func TestNewSMTPNotifierDefaults(t *testing.T) {
+	if _, err := NewSMTPNotifier(storage.SMTPConfig{Host: "mail.example.com"}, "Bot"); err == nil {
+		t.Error("accepted a config without a from address")
+	}
+	for _, tc := range []struct {
+		tls      string
+		wantTLS  string
+		wantPort int
+	}{
+		{"", "starttls", 587},
+		{"TLS", "tls", 465},
+		{"none", "none", 587},
+	} {
+		n, err := NewSMTPNotifier(storage.SMTPConfig{Host: "mail.example.com", From: "bot@example.com", TLS: tc.tls}, "Bot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.TLS != tc.wantTLS || n.Port != tc.wantPort {
+			t.Errorf("TLS %q: got mode %q port %d, want %q %d", tc.tls, n.TLS, n.Port, tc.wantTLS, tc.wantPort)
+		}
+	}
+}
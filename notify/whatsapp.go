@@ -0,0 +1,26 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"wa-server-go/storage"
+	"wa-server-go/whatsapp"
+)
+
+// WhatsAppNotifier sends reset codes from an admin-designated WhatsApp
+// instance to the number linked to the recipient's own instance.
+type WhatsAppNotifier struct {
+	SenderUserID string
+	BotName      string
+}
+
+func (n WhatsAppNotifier) SendResetOtp(to storage.OtpRecipient, otp string, expires time.Time) error {
+	phone := whatsapp.LinkedPhone(to.UserID)
+	if phone == "" {
+		return fmt.Errorf("no WhatsApp number is linked to %s", to.Email)
+	}
+	minutes := int(time.Until(expires).Round(time.Minute).Minutes())
+	text := fmt.Sprintf("🔑 Your %s password reset code is *%s*\n\nIt is valid for %d minutes. If you didn't request a reset, ignore this message.", n.BotName, otp, minutes)
+	return whatsapp.SendNotification(n.SenderUserID, phone, text)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"wa-server-go/storage"
+	"wa-server-go/whatsapp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requiredOrgRole is the role an organization member needs for a request
+// by default: viewer to read, agent for anything else. Routes that need
+// more add requireOrgRole.
+func requiredOrgRole(method string) string {
+	if method == fiber.MethodGet || method == fiber.MethodHead {
+		return storage.OrgRoleViewer
+	}
+	return storage.OrgRoleAgent
+}
+
+// requireOrgRole guards a route behind instanceMiddleware with a higher
+// role than its method's default. It is attached to each route rather than
+// matched on paths, which Fiber routes without regard to case.
+func requireOrgRole(needed string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("orgRole").(string)
+		if !storage.OrgRoleAtLeast(role, needed) {
+			return c.Status(403).JSON(fiber.Map{"error": "This action requires the " + needed + " role"})
+		}
+		return c.Next()
+	}
+}
+
+// instanceMiddleware picks the WhatsApp instance a request operates on and
+// enforces the caller's role for it. Requests act on the user's personal
+// instance unless they name an organization with the X-Org-Id header (or
+// ?org= query parameter).
+func instanceMiddleware(c *fiber.Ctx) error {
+	// Server administration and organization management don't act on an
+	// instance and do their own checks
+	path := strings.ToLower(c.Path())
+	if strings.HasPrefix(path, "/api/admin") || strings.HasPrefix(path, "/api/orgs") {
+		return c.Next()
+	}
+
+	userId := c.Locals("userId").(string)
+	orgId := c.Get("X-Org-Id")
+	if orgId == "" {
+		orgId = c.Query("org")
+	}
+
+	if orgId == "" {
+		c.Locals("instanceId", userId)
+		c.Locals("orgRole", storage.OrgRoleOwner)
+		return c.Next()
+	}
+
+	role := storage.OrgRole(orgId, userId)
+	if role == "" {
+		return c.Status(403).JSON(fiber.Map{"error": "You are not a member of this organization"})
+	}
+	if needed := requiredOrgRole(c.Method()); !storage.OrgRoleAtLeast(role, needed) {
+		return c.Status(403).JSON(fiber.Map{"error": "This action requires the " + needed + " role"})
+	}
+	c.Locals("instanceId", orgId)
+	c.Locals("orgId", orgId)
+	c.Locals("orgRole", role)
+	return c.Next()
+}
+
+// orgMember loads the organization in :id and checks the caller holds at
+// least the needed role in it. When it returns nil it has already written
+// the error response.
+func orgMember(c *fiber.Ctx, needed string) (*storage.Organization, string) {
+	org := storage.GetOrganization(c.Params("id"))
+	if org == nil {
+		c.Status(404).JSON(fiber.Map{"error": "Organization not found"})
+		return nil, ""
+	}
+	role := storage.OrgRole(org.ID, c.Locals("userId").(string))
+	if role == "" {
+		c.Status(404).JSON(fiber.Map{"error": "Organization not found"})
+		return nil, ""
+	}
+	if !storage.OrgRoleAtLeast(role, needed) {
+		c.Status(403).JSON(fiber.Map{"error": "This action requires the " + needed + " role"})
+		return nil, ""
+	}
+	return org, role
+}
+
+func orgView(org *storage.Organization, role string) fiber.Map {
+	view := fiber.Map{
+		"id":        org.ID,
+		"name":      org.Name,
+		"createdAt": org.CreatedAt,
+		"role":      role,
+		"members":   org.Members,
+	}
+	if storage.OrgRoleAtLeast(role, storage.OrgRoleAdmin) {
+		invites := make([]fiber.Map, 0, len(org.Invitations))
+		for _, inv := range org.Invitations {
+			invites = append(invites, invitationView(inv))
+		}
+		view["invitations"] = invites
+	}
+	return view
+}
+
+func invitationView(inv storage.OrgInvitation) fiber.Map {
+	return fiber.Map{
+		"id":        inv.ID,
+		"email":     inv.Email,
+		"role":      inv.Role,
+		"invitedBy": inv.InvitedBy,
+		"createdAt": inv.CreatedAt,
+		"expiresAt": time.UnixMilli(inv.ExpiresAt).UTC().Format(time.RFC3339),
+	}
+}
+
+func registerOrgRoutes(orgs fiber.Router) {
+	orgs.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(storage.ListUserOrganizations(c.Locals("userId").(string)))
+	})
+
+	orgs.Post("/", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name string `json:"name"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		user := storage.FindUserByID(c.Locals("userId").(string))
+		if user == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		org, err := storage.CreateOrganization(body.Name, user)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.created", map[string]interface{}{"name": org.Name})
+		return c.JSON(orgView(org, storage.OrgRoleOwner))
+	})
+
+	orgs.Post("/invitations/accept", func(c *fiber.Ctx) error {
+		type Req struct {
+			Token string `json:"token"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		user := storage.FindUserByID(c.Locals("userId").(string))
+		if user == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		org, err := storage.AcceptInvitation(body.Token, user)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		role := storage.OrgRole(org.ID, user.ID)
+		auditFor(c, org.ID, "org.member_joined", map[string]interface{}{"userId": user.ID, "role": role})
+		return c.JSON(orgView(org, role))
+	})
+
+	orgs.Get("/:id", func(c *fiber.Ctx) error {
+		org, role := orgMember(c, storage.OrgRoleViewer)
+		if org == nil {
+			return nil
+		}
+		return c.JSON(orgView(org, role))
+	})
+
+	orgs.Patch("/:id", func(c *fiber.Ctx) error {
+		type Req struct {
+			Name string `json:"name"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		org, role := orgMember(c, storage.OrgRoleAdmin)
+		if org == nil {
+			return nil
+		}
+		org, err := storage.RenameOrganization(org.ID, body.Name)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.renamed", map[string]interface{}{"name": org.Name})
+		return c.JSON(orgView(org, role))
+	})
+
+	orgs.Delete("/:id", func(c *fiber.Ctx) error {
+		org, _ := orgMember(c, storage.OrgRoleOwner)
+		if org == nil {
+			return nil
+		}
+		whatsapp.RemoveUserClient(org.ID)
+		if err := storage.DeleteOrganization(org.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		audit(c, "org.deleted", map[string]interface{}{"orgId": org.ID, "name": org.Name})
+		return c.JSON(fiber.Map{"success": true, "message": "Organization deleted"})
+	})
+
+	orgs.Post("/:id/invitations", func(c *fiber.Ctx) error {
+		type Req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		org, _ := orgMember(c, storage.OrgRoleAdmin)
+		if org == nil {
+			return nil
+		}
+		if body.Role == "" {
+			body.Role = storage.OrgRoleAgent
+		}
+		token, invite, err := storage.InviteToOrganization(org.ID, body.Email, body.Role, c.Locals("userEmail").(string))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.member_invited", map[string]interface{}{"email": invite.Email, "role": invite.Role})
+		view := invitationView(*invite)
+		view["token"] = token
+		return c.JSON(view)
+	})
+
+	orgs.Delete("/:id/invitations/:inviteId", func(c *fiber.Ctx) error {
+		org, _ := orgMember(c, storage.OrgRoleAdmin)
+		if org == nil {
+			return nil
+		}
+		if err := storage.RevokeInvitation(org.ID, c.Params("inviteId")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.invitation_revoked", map[string]interface{}{"invitationId": c.Params("inviteId")})
+		return c.JSON(fiber.Map{"success": true})
+	})
+
+	orgs.Patch("/:id/members/:userId", func(c *fiber.Ctx) error {
+		type Req struct {
+			Role string `json:"role"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		org, role := orgMember(c, storage.OrgRoleAdmin)
+		if org == nil {
+			return nil
+		}
+		org, err := storage.SetMemberRole(org.ID, c.Params("userId"), body.Role)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.member_role_changed", map[string]interface{}{"userId": c.Params("userId"), "role": body.Role})
+		return c.JSON(orgView(org, role))
+	})
+
+	orgs.Delete("/:id/members/:userId", func(c *fiber.Ctx) error {
+		// Members may always remove themselves; removing others needs admin
+		needed := storage.OrgRoleAdmin
+		if c.Params("userId") == c.Locals("userId").(string) {
+			needed = storage.OrgRoleViewer
+		}
+		org, role := orgMember(c, needed)
+		if org == nil {
+			return nil
+		}
+		org, err := storage.RemoveMember(org.ID, c.Params("userId"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.member_removed", map[string]interface{}{"userId": c.Params("userId")})
+		return c.JSON(orgView(org, role))
+	})
+
+	orgs.Post("/:id/transfer", func(c *fiber.Ctx) error {
+		type Req struct {
+			UserID string `json:"userId"`
+		}
+		var body Req
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+		org, _ := orgMember(c, storage.OrgRoleOwner)
+		if org == nil {
+			return nil
+		}
+		org, err := storage.TransferOwnership(org.ID, c.Locals("userId").(string), body.UserID)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		auditFor(c, org.ID, "org.ownership_transferred", map[string]interface{}{"userId": body.UserID})
+		return c.JSON(orgView(org, storage.OrgRoleAdmin))
+	})
+}
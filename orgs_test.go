@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"wa-server-go/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequiredOrgRole(t *testing.T) {
+	for method, want := range map[string]string{
+		"GET":    storage.OrgRoleViewer,
+		"HEAD":   storage.OrgRoleViewer,
+		"POST":   storage.OrgRoleAgent,
+		"PUT":    storage.OrgRoleAgent,
+		"PATCH":  storage.OrgRoleAgent,
+		"DELETE": storage.OrgRoleAgent,
+	} {
+		if got := requiredOrgRole(method); got != want {
+			t.Errorf("requiredOrgRole(%s) = %q, want %q", method, got, want)
+		}
+	}
+}
+
+// orgTestApp wires instanceMiddleware the way main does, with the caller's
+// user ID taken from a header in place of a session.
+func orgTestApp() *fiber.App {
+	app := fiber.New()
+	ok := func(c *fiber.Ctx) error { return c.SendString(c.Locals("instanceId").(string)) }
+	api := app.Group("/api", func(c *fiber.Ctx) error {
+		c.Locals("userId", c.Get("X-Test-User"))
+		return c.Next()
+	}, instanceMiddleware)
+	api.Get("/chats", ok)
+	api.Post("/send-message", ok)
+	api.Post("/disconnect", requireOrgRole(storage.OrgRoleAdmin), ok)
+	api.Get("/audit", requireOrgRole(storage.OrgRoleAdmin), ok)
+	return app
+}
+
+func TestInstanceMiddlewareRoles(t *testing.T) {
+	newUser := func(name string) *storage.AuthUser {
+		user, err := storage.Register(name+"@orgs.example.com", "password123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	owner, agent, viewer, outsider := newUser("owner"), newUser("agent"), newUser("viewer"), newUser("outsider")
+	org, err := storage.CreateOrganization("Support", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, role := range map[*storage.AuthUser]string{agent: storage.OrgRoleAgent, viewer: storage.OrgRoleViewer} {
+		token, _, err := storage.InviteToOrganization(org.ID, user.Email, role, owner.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.AcceptInvitation(token, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := orgTestApp()
+	for _, tc := range []struct {
+		user   *storage.AuthUser
+		org    string
+		method string
+		path   string
+		want   int
+	}{
+		{viewer, org.ID, "GET", "/api/chats", 200},
+		{viewer, org.ID, "POST", "/api/send-message", 403},
+		{agent, org.ID, "POST", "/api/send-message", 200},
+		{agent, org.ID, "POST", "/api/disconnect", 403},
+		{owner, org.ID, "POST", "/api/disconnect", 200},
+		{outsider, org.ID, "GET", "/api/chats", 403},
+		// Fiber matches routes without regard to case, so the role check
+		// must too
+		{agent, org.ID, "POST", "/api/Disconnect", 403},
+		{agent, org.ID, "POST", "/API/DISCONNECT", 403},
+		{viewer, org.ID, "GET", "/api/Audit", 403},
+		{owner, org.ID, "POST", "/api/Disconnect", 200},
+		// Without an organization, requests act on the caller's own instance
+		{agent, "", "POST", "/api/disconnect", 200},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("X-Test-User", tc.user.ID)
+		if tc.org != "" {
+			req.Header.Set("X-Org-Id", tc.org)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("%s %s as %s", tc.method, tc.path, tc.user.Email)
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status %d, want %d", name, resp.StatusCode, tc.want)
+		}
+	}
+}
@@ -108,10 +108,18 @@ async function handleLogin() {
 
   try {
     hideAuthMessage();
-    const data = await authApi('/login', {
+    let data = await authApi('/login', {
       method: 'POST',
       body: JSON.stringify({ email, password }),
     });
+    if (data.twoFactorRequired) {
+      const code = prompt('Enter the 6-digit code from your authenticator app (or a recovery code):');
+      if (!code) return showAuthMessage('Two-factor code is required');
+      data = await authApi('/login/2fa', {
+        method: 'POST',
+        body: JSON.stringify({ challenge: data.challenge, code: code.trim() }),
+      });
+    }
     localStorage.setItem('wa_token', data.token);
     authToken = data.token;
     showDashboard(data.user);
@@ -157,7 +165,7 @@ async function handleForgotPassword() {
     });
     resetEmail = email;
     showAuthView('reset');
-    showAuthMessage('Check your server console for the reset code.', 'success');
+    showAuthMessage('If that account exists, a reset code has been sent.', 'success');
   } catch (err) {
     showAuthMessage(err.message);
   }
@@ -186,6 +194,13 @@ async function handleResetPassword() {
 }
 
 function handleLogout() {
+  if (authToken) {
+    // Invalidate the session server-side; the UI logs out regardless
+    fetch(`${getApiBase()}/auth/logout`, {
+      method: 'POST',
+      headers: { 'Authorization': `Bearer ${authToken}` },
+    }).catch(() => {});
+  }
   localStorage.removeItem('wa_token');
   authToken = null;
   currentUser = null;
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wa-server-go/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ── Auth endpoint throttling ──
+//
+// Counters live in memory only; a restart resets them, while account
+// lockouts are persisted by storage.RecordLoginFailure.
+
+type windowCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+type failureRecord struct {
+	failures  int
+	lastFail  time.Time
+	notBefore time.Time
+}
+
+type authLimiter struct {
+	mu       sync.Mutex
+	windows  map[string]*windowCounter
+	failures map[string]*failureRecord
+}
+
+var limiter = newAuthLimiter()
+
+func newAuthLimiter() *authLimiter {
+	l := &authLimiter{
+		windows:  make(map[string]*windowCounter),
+		failures: make(map[string]*failureRecord),
+	}
+	go l.cleanup()
+	return l
+}
+
+func (l *authLimiter) cleanup() {
+	for range time.Tick(5 * time.Minute) {
+		now := time.Now()
+		l.mu.Lock()
+		for k, w := range l.windows {
+			if now.After(w.resetAt) {
+				delete(l.windows, k)
+			}
+		}
+		for k, f := range l.failures {
+			if now.Sub(f.lastFail) > time.Hour {
+				delete(l.failures, k)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// hit counts a request against key's fixed window and returns how long to
+// wait if the limit is exceeded. first is true only for the request that
+// crossed the limit, so callers can log it once per window.
+func (l *authLimiter) hit(key string, limit int, window time.Duration) (wait time.Duration, first bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.After(w.resetAt) {
+		w = &windowCounter{resetAt: now.Add(window)}
+		l.windows[key] = w
+	}
+	w.count++
+	if w.count > limit {
+		return w.resetAt.Sub(now), w.count == limit+1
+	}
+	return 0, false
+}
+
+// retryAfter reports how long key must wait before its next attempt.
+func (l *authLimiter) retryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.failures[key]; ok {
+		if wait := time.Until(f.notBefore); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// fail records a failed attempt. After the free failures, each one doubles
+// the delay before the next attempt is accepted.
+func (l *authLimiter) fail(key string, limits storage.RateLimitConfig) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok {
+		f = &failureRecord{}
+		l.failures[key] = f
+	}
+	f.failures++
+	f.lastFail = now
+	if excess := f.failures - limits.FreeFailures; excess > 0 {
+		delay := float64(limits.BaseDelaySeconds) * math.Pow(2, float64(excess-1))
+		delay = math.Min(delay, float64(limits.MaxDelaySeconds))
+		f.notBefore = now.Add(time.Duration(delay) * time.Second)
+	}
+}
+
+func (l *authLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.TrimSpace(strings.ToLower(email))
+}
+
+func tooManyRequests(c *fiber.Ctx, wait time.Duration, message string) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set("Retry-After", strconv.Itoa(seconds))
+	return c.Status(429).JSON(fiber.Map{"error": message, "retryAfter": seconds})
+}
+
+// authRateLimit caps requests per IP across the unauthenticated auth
+// endpoints, so bcrypt work can't be used to exhaust the CPU.
+func authRateLimit(c *fiber.Ctx) error {
+	limits := storage.GetGlobalConfig().Config.RateLimits.Effective()
+	wait, first := limiter.hit("ip:"+c.IP(), limits.IPRequestsPerMinute, time.Minute)
+	if wait > 0 {
+		if first {
+			storage.RecordAudit(storage.AuditEvent{
+				Action:    "auth.ip_throttled",
+				IP:        c.IP(),
+				UserAgent: c.Get("User-Agent"),
+				Details:   map[string]interface{}{"path": c.Path()},
+			})
+		}
+		return tooManyRequests(c, wait, "Too many requests — please slow down")
+	}
+	return c.Next()
+}
+
+// loginDelay returns how long the IP or email must wait after recent
+// failures before another login attempt is processed.
+func loginDelay(c *fiber.Ctx, email string) time.Duration {
+	wait := limiter.retryAfter("ip:" + c.IP())
+	if w := limiter.retryAfter(emailKey(email)); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// loginFailed records a wrong password against the IP, the email and the
+// account's lockout count.
+func loginFailed(c *fiber.Ctx, email string) {
+	userId := countLoginFailure(c, email)
+	storage.RecordAudit(storage.AuditEvent{
+		UserID:     userId,
+		Action:     "auth.login_failed",
+		Actor:      strings.TrimSpace(strings.ToLower(email)),
+		IP:         c.IP(),
+		UserAgent:  c.Get("User-Agent"),
+		Credential: "password",
+	})
+}
+
+// secondFactorFailed records a wrong 2FA code for a challenge's owner. It
+// counts exactly like a wrong password, so guessing codes is throttled and
+// locks the account the same way.
+func secondFactorFailed(c *fiber.Ctx, userId string, err error) {
+	auditFor(c, userId, "auth.2fa_failed", map[string]interface{}{"reason": err.Error()})
+	user := storage.FindUserByID(userId)
+	if user == nil {
+		limiter.fail("ip:"+c.IP(), storage.GetGlobalConfig().Config.RateLimits.Effective())
+		return
+	}
+	countLoginFailure(c, user.Email)
+}
+
+// countLoginFailure adds a failure to the IP and email delays and the
+// account's lockout count, and logs an audit event if it locked the account.
+// It returns the account's user ID, or "" for unknown emails.
+func countLoginFailure(c *fiber.Ctx, email string) string {
+	limits := storage.GetGlobalConfig().Config.RateLimits.Effective()
+	limiter.fail("ip:"+c.IP(), limits)
+	limiter.fail(emailKey(email), limits)
+
+	userId, lockedUntil := storage.RecordLoginFailure(email)
+	if lockedUntil != nil {
+		storage.RecordAudit(storage.AuditEvent{
+			UserID:     userId,
+			Action:     "auth.account_locked",
+			Actor:      strings.TrimSpace(strings.ToLower(email)),
+			IP:         c.IP(),
+			UserAgent:  c.Get("User-Agent"),
+			Credential: "password",
+			Details:    map[string]interface{}{"lockedUntil": lockedUntil.UTC().Format(time.RFC3339)},
+		})
+	}
+	return userId
+}
+
+// loginSucceeded clears the failure history of an account that passed every
+// factor. The IP's history is kept so one valid account can't be used to
+// reset an attacker's delay.
+func loginSucceeded(user *storage.AuthUser) {
+	limiter.reset(emailKey(user.Email))
+	storage.ClearLoginFailures(user.ID)
+}
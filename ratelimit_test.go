@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"wa-server-go/storage"
+)
+
+func TestLimiterWindow(t *testing.T) {
+	l := newAuthLimiter()
+	window := 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		if wait, _ := l.hit("ip:a", 3, window); wait != 0 {
+			t.Fatalf("request %d was throttled", i+1)
+		}
+	}
+	wait, first := l.hit("ip:a", 3, window)
+	if wait <= 0 || wait > window || !first {
+		t.Errorf("4th request: wait %v first %v, want a wait within the window and first", wait, first)
+	}
+	if _, first := l.hit("ip:a", 3, window); first {
+		t.Error("first reported twice in one window")
+	}
+	if wait, _ := l.hit("ip:b", 3, window); wait != 0 {
+		t.Error("a different key shares the window")
+	}
+
+	time.Sleep(window + 10*time.Millisecond)
+	if wait, _ := l.hit("ip:a", 3, window); wait != 0 {
+		t.Error("still throttled after the window ended")
+	}
+}
+
+func TestLimiterFailureBackoff(t *testing.T) {
+	l := newAuthLimiter()
+	limits := storage.RateLimitConfig{FreeFailures: 2, BaseDelaySeconds: 2, MaxDelaySeconds: 5}
+
+	for i := 0; i < limits.FreeFailures; i++ {
+		l.fail("email:a", limits)
+		if wait := l.retryAfter("email:a"); wait != 0 {
+			t.Fatalf("free failure %d imposed a %v delay", i+1, wait)
+		}
+	}
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		l.fail("email:a", limits)
+		if wait := l.retryAfter("email:a"); wait <= want-time.Second || wait > want {
+			t.Errorf("delay = %v, want about %v", wait, want)
+		}
+	}
+	if wait := l.retryAfter("email:b"); wait != 0 {
+		t.Error("a different key shares the delay")
+	}
+
+	l.reset("email:a")
+	if wait := l.retryAfter("email:a"); wait != 0 {
+		t.Errorf("delay after reset = %v", wait)
+	}
+	l.fail("email:a", limits)
+	if wait := l.retryAfter("email:a"); wait != 0 {
+		t.Error("reset didn't restore the free failures")
+	}
+}
+
+func TestEmailKeyNormalizes(t *testing.T) {
+	if emailKey("  User@Example.COM ") != emailKey("user@example.com") {
+		t.Error("email keys differ by case or spacing")
+	}
+}
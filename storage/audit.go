@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	systemAuditPath = filepath.Join(dataDir, "audit.jsonl")
+	auditMutex      = &sync.Mutex{}
+)
+
+// AuditEvent is one append-only entry in a security audit log.
+type AuditEvent struct {
+	Time      string `json:"time"`
+	UserID    string `json:"userId,omitempty"`
+	Action    string `json:"action"`
+	Actor     string `json:"actor,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Credential is how the actor authenticated: "cookie" for the
+	// dashboard session cookie, "bearer" for an Authorization header, or
+	// "password" for the login endpoints themselves.
+	Credential string                 `json:"credential,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+func auditPath(userId string) string {
+	if userId == "" {
+		return systemAuditPath
+	}
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return systemAuditPath
+	}
+	return filepath.Join(usersDir, safeId, "audit.jsonl")
+}
+
+// RecordAudit appends ev to the user's audit log, or to the system log when
+// the event isn't tied to a known account.
+func RecordAudit(ev AuditEvent) {
+	if ev.Time == "" {
+		ev.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	p := auditPath(ev.UserID)
+	os.MkdirAll(filepath.Dir(p), 0755)
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// AuditFilter narrows QueryAudit results. Zero values match everything.
+type AuditFilter struct {
+	// Action matches exactly, or as a prefix when it ends in "." or "*"
+	// (e.g. "group." or "auth.login*").
+	Action     string
+	Actor      string
+	IP         string
+	Credential string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+func (f AuditFilter) matches(ev AuditEvent) bool {
+	if f.Action != "" {
+		prefix := strings.TrimSuffix(f.Action, "*")
+		if prefix != f.Action || strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(ev.Action, prefix) {
+				return false
+			}
+		} else if ev.Action != f.Action {
+			return false
+		}
+	}
+	if f.Actor != "" && !strings.EqualFold(ev.Actor, f.Actor) {
+		return false
+	}
+	if f.IP != "" && ev.IP != f.IP {
+		return false
+	}
+	if f.Credential != "" && ev.Credential != f.Credential {
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339, ev.Time)
+		if err != nil {
+			return false
+		}
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && t.After(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryAudit returns the user's audit events matching filter, newest first.
+// Pass an empty userId for the system log.
+func QueryAudit(userId string, filter AuditFilter) []AuditEvent {
+	matched := make([]AuditEvent, 0)
+	if userId != "" {
+		if _, err := sanitizeUserId(userId); err != nil {
+			return matched
+		}
+	}
+
+	auditMutex.Lock()
+	f, err := os.Open(auditPath(userId))
+	if err != nil {
+		auditMutex.Unlock()
+		return matched
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+			continue
+		}
+		if filter.matches(ev) {
+			matched = append(matched, ev)
+		}
+	}
+	f.Close()
+	auditMutex.Unlock()
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	if filter.Offset > 0 {
+		if filter.Offset >= len(matched) {
+			return make([]AuditEvent, 0)
+		}
+		matched = matched[filter.Offset:]
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched
+}
+
+// ExportAudit writes the matching events to w as JSON Lines, oldest first.
+func ExportAudit(userId string, filter AuditFilter, w io.Writer) error {
+	events := QueryAudit(userId, filter)
+	enc := json.NewEncoder(w)
+	for i := len(events) - 1; i >= 0; i-- {
+		if err := enc.Encode(events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
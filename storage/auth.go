@@ -1,14 +1,18 @@
 package storage
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
-	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -20,22 +24,42 @@ import (
 )
 
 var (
-	authPath        = filepath.Join("data", "auth.json")
-	authMutex       = &sync.RWMutex{}
-	bcryptRounds    = 12
-	maxOtpAttempts  = 5
-	emailRegex      = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+	authPath       = filepath.Join("data", "auth.json")
+	authMutex      = &sync.RWMutex{}
+	bcryptRounds   = 12
+	maxOtpAttempts = 5
+	totpIssuer     = "WA Bot Server"
+	totpPeriod     = int64(30)
+	totpSkew       = int64(1)
+	recoveryCodes  = 10
+	challengeTTL   = 5 * time.Minute
+	emailRegex     = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+
+	ErrAccountLocked = errors.New("Too many failed attempts — this account is temporarily locked")
 )
 
 type AuthUser struct {
-	ID               string `json:"id"`
-	Email            string `json:"email"`
-	PasswordHash     string `json:"passwordHash"`
-	Token            string `json:"token"`
-	ResetOtpHash     *string `json:"resetOtpHash"`
-	ResetOtpExpires  *int64 `json:"resetOtpExpires"`
-	ResetOtpAttempts *int   `json:"resetOtpAttempts"`
-	CreatedAt        string `json:"createdAt"`
+	ID               string        `json:"id"`
+	Email            string        `json:"email"`
+	PasswordHash     string        `json:"passwordHash"`
+	ResetOtpHash     *string       `json:"resetOtpHash"`
+	ResetOtpExpires  *int64        `json:"resetOtpExpires"`
+	ResetOtpAttempts *int          `json:"resetOtpAttempts"`
+	CreatedAt        string        `json:"createdAt"`
+	Role             string        `json:"role"`
+	Disabled         bool          `json:"disabled"`
+	FailedLogins     int           `json:"failedLogins"`
+	LockedUntil      int64         `json:"lockedUntil"`
+	Sessions         []AuthSession `json:"sessions,omitempty"`
+
+	TotpSecret         *string  `json:"totpSecret"`
+	TotpEnabled        bool     `json:"totpEnabled"`
+	TotpRequired       bool     `json:"totpRequired"`
+	TotpLastStep       int64    `json:"totpLastStep"`
+	RecoveryCodeHashes []string `json:"recoveryCodeHashes,omitempty"`
+	LoginChallengeHash *string  `json:"loginChallengeHash"`
+	LoginChallengeExp  *int64   `json:"loginChallengeExpires"`
+	LoginChallengeTry  *int     `json:"loginChallengeAttempts"`
 }
 
 type AuthData struct {
@@ -47,7 +71,23 @@ type AuthData struct {
 func loadAuth() AuthData {
 	authMutex.RLock()
 	defer authMutex.RUnlock()
+	return readAuthFile()
+}
+
+// updateAuth runs fn against the current auth data while holding the write
+// lock, so concurrent read-modify-write cycles can't clobber each other.
+// The file is only written back when fn reports a change.
+func updateAuth(fn func(auth *AuthData) bool) {
+	authMutex.Lock()
+	defer authMutex.Unlock()
 
+	data := readAuthFile()
+	if fn(&data) {
+		writeAuthFile(data)
+	}
+}
+
+func readAuthFile() AuthData {
 	var data AuthData
 	data.Users = make([]AuthUser, 0)
 
@@ -67,10 +107,7 @@ func loadAuth() AuthData {
 	return data
 }
 
-func saveAuth(data AuthData) {
-	authMutex.Lock()
-	defer authMutex.Unlock()
-
+func writeAuthFile(data AuthData) {
 	os.MkdirAll(filepath.Dir(authPath), 0755)
 	bytes, _ := json.MarshalIndent(data, "", "  ")
 	os.WriteFile(authPath, bytes, 0644)
@@ -114,17 +151,15 @@ func FindUserByEmail(email string) *AuthUser {
 	return nil
 }
 
-func FindUserByToken(token string) *AuthUser {
-	if token == "" {
-		return nil
+// registrationAllowed checks whether an account can be created for the
+// normalized email.
+func registrationAllowed(auth AuthData, email string, config ServerConfig) error {
+	if config.RegistrationClosed && len(auth.Users) > 0 && !isAdminEmail(email) {
+		return errors.New("Registration is closed")
 	}
-	auth := loadAuth()
-	tokenBytes := []byte(token)
-
 	for _, u := range auth.Users {
-		storedBytes := []byte(u.Token)
-		if len(tokenBytes) == len(storedBytes) && subtle.ConstantTimeCompare(tokenBytes, storedBytes) == 1 {
-			return &u
+		if u.Email == email {
+			return errors.New("An account with this email already exists")
 		}
 	}
 	return nil
@@ -143,29 +178,40 @@ func Register(email, password string) (*AuthUser, error) {
 		return nil, errors.New("Password must be at least 6 characters")
 	}
 
-	auth := loadAuth()
-	for _, u := range auth.Users {
-		if u.Email == normalized {
-			return nil, errors.New("An account with this email already exists")
-		}
+	config := GetGlobalConfig().Config
+	if err := registrationAllowed(loadAuth(), normalized, config); err != nil {
+		return nil, err
 	}
 
+	// Hashed before taking the lock, so the checks are repeated inside it
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptRounds)
 	if err != nil {
 		return nil, err
 	}
 
-	user := AuthUser{
-		ID:           generateUUID(),
-		Email:        normalized,
-		PasswordHash: string(hash),
-		Token:        generateToken(),
-		CreatedAt:    time.Now().UTC().Format(time.RFC3339),
+	var user AuthUser
+	updateAuth(func(auth *AuthData) bool {
+		if err = registrationAllowed(*auth, normalized, config); err != nil {
+			return false
+		}
+		role := RoleUser
+		if len(auth.Users) == 0 || isAdminEmail(normalized) {
+			role = RoleAdmin
+		}
+		user = AuthUser{
+			ID:           generateUUID(),
+			Email:        normalized,
+			PasswordHash: string(hash),
+			CreatedAt:    time.Now().UTC().Format(time.RFC3339),
+			Role:         role,
+		}
+		auth.Users = append(auth.Users, user)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	auth.Users = append(auth.Users, user)
-	saveAuth(auth)
-
 	return &user, nil
 }
 
@@ -188,45 +234,118 @@ func Login(email, password string) (*AuthUser, error) {
 	if user == nil {
 		return nil, errors.New("Invalid email or password")
 	}
+	// Checked before bcrypt so a locked account costs no hashing work
+	if user.LockedUntil > time.Now().UnixMilli() {
+		return nil, ErrAccountLocked
+	}
 
 	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
 		return nil, errors.New("Invalid email or password")
 	}
+	if user.Disabled {
+		return nil, errors.New("This account has been disabled")
+	}
 
+	// Failure counts are cleared by ClearLoginFailures once any second
+	// factor has also passed, not here
 	return user, nil
 }
 
-func ForgotPassword(email string) error {
+// RecordLoginFailure counts a failed password for the account with this
+// email and locks it once the configured threshold is reached. It returns
+// the user ID ("" for unknown emails) and, if this failure triggered a
+// lockout, when the lock expires.
+func RecordLoginFailure(email string) (string, *time.Time) {
 	normalized := strings.TrimSpace(strings.ToLower(email))
+	limits := GetGlobalConfig().Config.RateLimits.Effective()
+	now := time.Now()
+
+	userId := ""
+	var lockedUntil *time.Time
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.Email != normalized {
+				continue
+			}
+			userId = user.ID
+			if user.LockedUntil > now.UnixMilli() {
+				return false
+			}
+			user.FailedLogins++
+			if user.FailedLogins >= limits.LockoutThreshold {
+				until := now.Add(time.Duration(limits.LockoutMinutes) * time.Minute)
+				user.LockedUntil = until.UnixMilli()
+				user.FailedLogins = 0
+				lockedUntil = &until
+			}
+			return true
+		}
+		return false
+	})
+	return userId, lockedUntil
+}
 
-	auth := loadAuth()
-	foundIdx := -1
-	for i, u := range auth.Users {
-		if u.Email == normalized {
-			foundIdx = i
-			break
+// ClearLoginFailures resets an account's failure count after a complete
+// login.
+func ClearLoginFailures(userId string) {
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.ID != userId {
+				continue
+			}
+			if user.FailedLogins == 0 && user.LockedUntil == 0 {
+				return false
+			}
+			user.FailedLogins = 0
+			user.LockedUntil = 0
+			return true
 		}
-	}
+		return false
+	})
+}
 
-	if foundIdx == -1 {
-		return nil // Silently succeed
-	}
+func ForgotPassword(email string) error {
+	normalized := strings.TrimSpace(strings.ToLower(email))
 
 	otp := generateOtp()
 	hash := sha256.Sum256([]byte(otp))
 	hashStr := hex.EncodeToString(hash[:])
+	expiresAt := time.Now().Add(15 * time.Minute)
+
+	userId := ""
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.Email != normalized {
+				continue
+			}
+			expires := expiresAt.UnixMilli()
+			attempts := 0
+			user.ResetOtpHash = &hashStr
+			user.ResetOtpExpires = &expires
+			user.ResetOtpAttempts = &attempts
+			userId = user.ID
+			return true
+		}
+		return false
+	})
+	if userId == "" {
+		return nil // Silently succeed
+	}
 
-	expires := time.Now().UnixMilli() + 15*60*1000
-	attempts := 0
-
-	auth.Users[foundIdx].ResetOtpHash = &hashStr
-	auth.Users[foundIdx].ResetOtpExpires = &expires
-	auth.Users[foundIdx].ResetOtpAttempts = &attempts
-	saveAuth(auth)
-
-	fmt.Printf("\n🔑 Password reset OTP for %s: %s\n", normalized, otp)
-	fmt.Printf("   Valid for 15 minutes.\n\n")
+	// Callers must not learn whether the email exists, so the code is
+	// delivered in the background (an SMTP round trip would otherwise make
+	// known emails answer slower) and failures are only logged.
+	to := OtpRecipient{UserID: userId, Email: normalized}
+	notifier := getOtpNotifier()
+	go func() {
+		if err := notifier.SendResetOtp(to, otp, expiresAt); err != nil {
+			fmt.Printf("Failed to deliver password reset OTP to %s: %v\n", normalized, err)
+		}
+	}()
 	return nil
 }
 
@@ -240,62 +359,380 @@ func ResetPassword(email, otp, newPassword string) (*AuthUser, error) {
 		return nil, errors.New("Password must be at least 6 characters")
 	}
 
-	auth := loadAuth()
-	foundIdx := -1
-	for i, u := range auth.Users {
-		if u.Email == normalized {
-			foundIdx = i
-			break
-		}
+	// Hashed before taking the lock, so the attempt count is checked and
+	// updated in one step
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcryptRounds)
+	if err != nil {
+		return nil, err
 	}
+	otpHashObj := sha256.Sum256([]byte(otp))
+	otpHash := hex.EncodeToString(otpHashObj[:])
 
-	if foundIdx == -1 {
-		return nil, errors.New("Invalid email or OTP")
-	}
-	user := &auth.Users[foundIdx]
+	var result *AuthUser
+	err = errors.New("Invalid email or OTP")
+	updateAuth(func(auth *AuthData) bool {
+		var user *AuthUser
+		for i := range auth.Users {
+			if auth.Users[i].Email == normalized {
+				user = &auth.Users[i]
+				break
+			}
+		}
+		if user == nil {
+			return false
+		}
 
-	attempts := 0
-	if user.ResetOtpAttempts != nil {
-		attempts = *user.ResetOtpAttempts
-	}
+		attempts := 0
+		if user.ResetOtpAttempts != nil {
+			attempts = *user.ResetOtpAttempts
+		}
+		if attempts >= maxOtpAttempts {
+			user.ResetOtpHash = nil
+			user.ResetOtpExpires = nil
+			user.ResetOtpAttempts = nil
+			err = errors.New("Too many attempts — please request a new reset code")
+			return true
+		}
 
-	if attempts >= maxOtpAttempts {
+		if user.ResetOtpHash == nil || *user.ResetOtpHash != otpHash {
+			attempts++
+			user.ResetOtpAttempts = &attempts
+			return true
+		}
+
+		if user.ResetOtpExpires == nil || time.Now().UnixMilli() > *user.ResetOtpExpires {
+			user.ResetOtpHash = nil
+			user.ResetOtpExpires = nil
+			user.ResetOtpAttempts = nil
+			err = errors.New("OTP has expired — please request a new one")
+			return true
+		}
+
+		user.PasswordHash = string(newHash)
+		user.Sessions = nil // a password reset signs out every device
+		// Proving control of the email lifts a lockout too
+		user.FailedLogins = 0
+		user.LockedUntil = 0
 		user.ResetOtpHash = nil
 		user.ResetOtpExpires = nil
 		user.ResetOtpAttempts = nil
-		saveAuth(auth)
-		return nil, errors.New("Too many attempts — please request a new reset code")
+		updated := *user
+		result, err = &updated, nil
+		return true
+	})
+	return result, err
+}
+
+// ── Two-Factor Authentication ──
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", bin%1000000)
+}
+
+// verifyTotp checks code against the secret, allowing totpSkew steps of clock
+// drift. It returns the matched step, which must be newer than lastStep so a
+// code can't be replayed.
+func verifyTotp(secret string, code string, lastStep int64) (int64, bool) {
+	key, err := base32NoPad.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
 	}
+	code = strings.ReplaceAll(code, " ", "")
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
 
-	otpHashObj := sha256.Sum256([]byte(otp))
-	otpHash := hex.EncodeToString(otpHashObj[:])
+func generateRecoveryCode() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	code := strings.ToLower(base32NoPad.EncodeToString(b))
+	return code[:4] + "-" + code[4:]
+}
 
-	if user.ResetOtpHash == nil || *user.ResetOtpHash != otpHash {
-		attempts++
-		user.ResetOtpAttempts = &attempts
-		saveAuth(auth)
-		return nil, errors.New("Invalid email or OTP")
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkSecondFactor verifies a TOTP or recovery code for user, consuming the
+// recovery code or TOTP step on success. The caller must persist the user.
+func checkSecondFactor(user *AuthUser, code string) bool {
+	if !user.TotpEnabled || user.TotpSecret == nil || code == "" {
+		return false
 	}
+	if step, ok := verifyTotp(*user.TotpSecret, code, user.TotpLastStep); ok {
+		user.TotpLastStep = step
+		return true
+	}
+	hash := hashRecoveryCode(code)
+	for i, h := range user.RecoveryCodeHashes {
+		if hmac.Equal([]byte(h), []byte(hash)) {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i], user.RecoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
 
-	if user.ResetOtpExpires == nil || time.Now().UnixMilli() > *user.ResetOtpExpires {
-		user.ResetOtpHash = nil
-		user.ResetOtpExpires = nil
-		user.ResetOtpAttempts = nil
-		saveAuth(auth)
-		return nil, errors.New("OTP has expired — please request a new one")
+func newRecoveryCodes(user *AuthUser) []string {
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		codes[i] = generateRecoveryCode()
+		hashes[i] = hashRecoveryCode(codes[i])
 	}
+	user.RecoveryCodeHashes = hashes
+	return codes
+}
 
-	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcryptRounds)
-	if err != nil {
-		return nil, err
+// TwoFactorRequired reports whether the user must have 2FA enabled before
+// using the API, either because it was enforced on their account or because
+// the server enforces it for everyone.
+func TwoFactorRequired(user *AuthUser) bool {
+	return user.TotpRequired || GetGlobalConfig().Config.Require2FA
+}
+
+// BeginTotpSetup generates a new (not yet active) TOTP secret for the user
+// and returns it together with the otpauth:// URI for authenticator apps.
+func BeginTotpSetup(userId string) (string, string, error) {
+	b := make([]byte, 20)
+	rand.Read(b)
+	secret := base32NoPad.EncodeToString(b)
+
+	var email string
+	var setupErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID != userId {
+				continue
+			}
+			if auth.Users[i].TotpEnabled {
+				setupErr = errors.New("Two-factor authentication is already enabled")
+				return false
+			}
+			auth.Users[i].TotpSecret = &secret
+			email = auth.Users[i].Email
+			return true
+		}
+		setupErr = errors.New("User not found")
+		return false
+	})
+	if setupErr != nil {
+		return "", "", setupErr
 	}
 
-	user.PasswordHash = string(newHash)
-	user.Token = generateToken()
-	user.ResetOtpHash = nil
-	user.ResetOtpExpires = nil
-	user.ResetOtpAttempts = nil
-	saveAuth(auth)
+	label := url.PathEscape(totpIssuer + ":" + email)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	uri := fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+	return secret, uri, nil
+}
 
-	return user, nil
+// EnableTotp activates the pending secret once the user proves their
+// authenticator produces valid codes. It returns freshly generated recovery
+// codes, which are only ever shown this once.
+func EnableTotp(userId string, code string) ([]string, error) {
+	var codes []string
+	var enableErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.ID != userId {
+				continue
+			}
+			if user.TotpEnabled {
+				enableErr = errors.New("Two-factor authentication is already enabled")
+				return false
+			}
+			if user.TotpSecret == nil {
+				enableErr = errors.New("Start two-factor setup first")
+				return false
+			}
+			step, ok := verifyTotp(*user.TotpSecret, code, 0)
+			if !ok {
+				enableErr = errors.New("Invalid authentication code")
+				return false
+			}
+			user.TotpEnabled = true
+			user.TotpLastStep = step
+			codes = newRecoveryCodes(user)
+			return true
+		}
+		enableErr = errors.New("User not found")
+		return false
+	})
+	return codes, enableErr
+}
+
+// DisableTotp turns 2FA off after re-checking the password and a current
+// second factor.
+func DisableTotp(userId string, password string, code string) error {
+	var disableErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.ID != userId {
+				continue
+			}
+			if !user.TotpEnabled {
+				disableErr = errors.New("Two-factor authentication is not enabled")
+				return false
+			}
+			if TwoFactorRequired(user) {
+				disableErr = errors.New("Two-factor authentication is enforced for this account")
+				return false
+			}
+			if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+				disableErr = errors.New("Invalid password")
+				return false
+			}
+			if !checkSecondFactor(user, code) {
+				disableErr = errors.New("Invalid authentication code")
+				return false
+			}
+			user.TotpEnabled = false
+			user.TotpSecret = nil
+			user.TotpLastStep = 0
+			user.RecoveryCodeHashes = nil
+			return true
+		}
+		disableErr = errors.New("User not found")
+		return false
+	})
+	return disableErr
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a
+// current second factor.
+func RegenerateRecoveryCodes(userId string, code string) ([]string, error) {
+	var codes []string
+	var regenErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.ID != userId {
+				continue
+			}
+			if !checkSecondFactor(user, code) {
+				regenErr = errors.New("Invalid authentication code")
+				return false
+			}
+			codes = newRecoveryCodes(user)
+			return true
+		}
+		regenErr = errors.New("User not found")
+		return false
+	})
+	return codes, regenErr
+}
+
+// CreateLoginChallenge is called after a correct password for a 2FA user.
+// The returned challenge token must be presented with a code to
+// CompleteLoginChallenge within challengeTTL.
+func CreateLoginChallenge(userId string) string {
+	challenge := generateToken()
+	hash := hashToken(challenge)
+	expires := time.Now().Add(challengeTTL).UnixMilli()
+	attempts := 0
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID == userId {
+				auth.Users[i].LoginChallengeHash = &hash
+				auth.Users[i].LoginChallengeExp = &expires
+				auth.Users[i].LoginChallengeTry = &attempts
+				return true
+			}
+		}
+		return false
+	})
+	return challenge
+}
+
+// LoginChallengeOwner returns the ID of the user a pending login challenge
+// belongs to, or "" if it matches none.
+func LoginChallengeOwner(challenge string) string {
+	if challenge == "" {
+		return ""
+	}
+	hash := hashToken(challenge)
+	auth := loadAuth()
+	for _, u := range auth.Users {
+		if u.LoginChallengeHash != nil && hmac.Equal([]byte(*u.LoginChallengeHash), []byte(hash)) {
+			return u.ID
+		}
+	}
+	return ""
+}
+
+// CompleteLoginChallenge verifies the second login step and returns the user
+// on success.
+func CompleteLoginChallenge(challenge string, code string) (*AuthUser, error) {
+	if challenge == "" || code == "" {
+		return nil, errors.New("Challenge and code are required")
+	}
+	hash := hashToken(challenge)
+	now := time.Now().UnixMilli()
+
+	var result *AuthUser
+	var loginErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			user := &auth.Users[i]
+			if user.LoginChallengeHash == nil || !hmac.Equal([]byte(*user.LoginChallengeHash), []byte(hash)) {
+				continue
+			}
+			endChallenge := func() {
+				user.LoginChallengeHash = nil
+				user.LoginChallengeExp = nil
+				user.LoginChallengeTry = nil
+			}
+			if user.LoginChallengeExp == nil || now > *user.LoginChallengeExp {
+				endChallenge()
+				loginErr = errors.New("Login challenge has expired — please log in again")
+				return true
+			}
+			attempts := 0
+			if user.LoginChallengeTry != nil {
+				attempts = *user.LoginChallengeTry
+			}
+			if attempts >= maxOtpAttempts {
+				endChallenge()
+				loginErr = errors.New("Too many attempts — please log in again")
+				return true
+			}
+			if !checkSecondFactor(user, code) {
+				attempts++
+				user.LoginChallengeTry = &attempts
+				loginErr = errors.New("Invalid authentication code")
+				return true
+			}
+			endChallenge()
+			copied := *user
+			result = &copied
+			return true
+		}
+		loginErr = errors.New("Login challenge has expired — please log in again")
+		return false
+	})
+	return result, loginErr
 }
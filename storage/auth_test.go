@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingNotifier hands each delivery to the test and blocks until the
+// test releases it, like a slow mail server would.
+type blockingNotifier struct {
+	sent    chan string
+	release chan struct{}
+}
+
+func (n blockingNotifier) SendResetOtp(to OtpRecipient, otp string, expires time.Time) error {
+	n.sent <- otp
+	<-n.release
+	return nil
+}
+
+func TestForgotPasswordDoesNotWaitForDelivery(t *testing.T) {
+	user := newTestUser(t)
+	n := blockingNotifier{sent: make(chan string, 1), release: make(chan struct{})}
+	SetOtpNotifier(n)
+	defer SetOtpNotifier(StdoutNotifier{})
+	defer close(n.release)
+
+	done := make(chan struct{})
+	go func() {
+		ForgotPassword(user.Email)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ForgotPassword waited for the notifier")
+	}
+
+	var otp string
+	select {
+	case otp = <-n.sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the reset code was never delivered")
+	}
+	if _, err := ResetPassword(user.Email, otp, "new-password"); err != nil {
+		t.Fatalf("the delivered code was rejected: %v", err)
+	}
+
+	ForgotPassword("nobody@example.com")
+	select {
+	case <-n.sent:
+		t.Error("a code was delivered for an unknown email")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func lockTestUser(t *testing.T, user *AuthUser) {
+	t.Helper()
+	threshold := GetGlobalConfig().Config.RateLimits.Effective().LockoutThreshold
+	for i := 1; i < threshold; i++ {
+		if _, until := RecordLoginFailure(user.Email); until != nil {
+			t.Fatalf("locked after %d failures, want %d", i, threshold)
+		}
+	}
+	if userId, until := RecordLoginFailure(user.Email); userId != user.ID || until == nil {
+		t.Fatalf("RecordLoginFailure = %q, %v; want the account locked", userId, until)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	user := newTestUser(t)
+	lockTestUser(t, user)
+
+	if _, err := Login(user.Email, "password123"); err != ErrAccountLocked {
+		t.Fatalf("Login on a locked account = %v, want ErrAccountLocked", err)
+	}
+	if userId, until := RecordLoginFailure(user.Email); userId != user.ID || until != nil {
+		t.Error("a failure while locked extended the lock")
+	}
+
+	ClearLoginFailures(user.ID)
+	if _, err := Login(user.Email, "password123"); err != nil {
+		t.Fatalf("Login after ClearLoginFailures: %v", err)
+	}
+}
+
+func TestLoginKeepsFailuresUntilCleared(t *testing.T) {
+	user := newTestUser(t)
+	RecordLoginFailure(user.Email)
+	if _, err := Login(user.Email, "password123"); err != nil {
+		t.Fatal(err)
+	}
+	// A correct password alone may still be followed by a wrong 2FA code
+	if FindUserByID(user.ID).FailedLogins != 1 {
+		t.Error("Login cleared the failure count before the login completed")
+	}
+	ClearLoginFailures(user.ID)
+	if FindUserByID(user.ID).FailedLogins != 0 {
+		t.Error("ClearLoginFailures kept the failure count")
+	}
+}
+
+func TestResetPasswordUnlocks(t *testing.T) {
+	user := newTestUser(t)
+	lockTestUser(t, user)
+
+	n := blockingNotifier{sent: make(chan string, 1), release: make(chan struct{})}
+	close(n.release)
+	SetOtpNotifier(n)
+	defer SetOtpNotifier(StdoutNotifier{})
+	ForgotPassword(user.Email)
+	otp := <-n.sent
+
+	if _, err := ResetPassword(user.Email, otp, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Login(user.Email, "new-password"); err != nil {
+		t.Fatalf("Login after a password reset: %v", err)
+	}
+}
+
+func TestRegisterConcurrently(t *testing.T) {
+	base := testUserSeq.Add(1)
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := Register(fmt.Sprintf("parallel%d-%d@example.com", base, i), "password123")
+			errs <- err
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := Register(fmt.Sprintf("same%d@example.com", base), "password123")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed != 9 {
+		t.Errorf("%d registrations failed, want 9 (the duplicates of one email)", failed)
+	}
+	for i := 0; i < 10; i++ {
+		if FindUserByEmail(fmt.Sprintf("parallel%d-%d@example.com", base, i)) == nil {
+			t.Errorf("account %d was lost", i)
+		}
+	}
+}
+
+func TestResetPasswordAttemptCapHoldsConcurrently(t *testing.T) {
+	user := newTestUser(t)
+	n := blockingNotifier{sent: make(chan string, 1), release: make(chan struct{})}
+	close(n.release)
+	SetOtpNotifier(n)
+	defer SetOtpNotifier(StdoutNotifier{})
+	ForgotPassword(user.Email)
+	otp := <-n.sent
+
+	wrong := "000000"
+	if otp == wrong {
+		wrong = "111111"
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3*maxOtpAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ResetPassword(user.Email, wrong, "new-password")
+		}()
+	}
+	wg.Wait()
+
+	if _, err := ResetPassword(user.Email, otp, "new-password"); err == nil {
+		t.Error("the code still worked after more wrong guesses than allowed")
+	}
+}
+
+func TestResetPasswordKeepsConcurrentChanges(t *testing.T) {
+	user := newTestUser(t)
+	other := newTestUser(t)
+	n := blockingNotifier{sent: make(chan string, 1), release: make(chan struct{})}
+	close(n.release)
+	SetOtpNotifier(n)
+	defer SetOtpNotifier(StdoutNotifier{})
+	ForgotPassword(user.Email)
+	otp := <-n.sent
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ResetPassword(user.Email, otp, "new-password")
+	}()
+	go func() {
+		defer wg.Done()
+		RecordLoginFailure(other.Email)
+	}()
+	wg.Wait()
+
+	if FindUserByID(other.ID).FailedLogins != 1 {
+		t.Error("a login failure recorded during a password reset was lost")
+	}
+	if _, err := Login(user.Email, "new-password"); err != nil {
+		t.Errorf("Login with the new password: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import "time"
+
+// maxCallLog is how many calls are kept per instance.
+const maxCallLog = 200
+
+// CallPolicy decides what happens to incoming calls.
+type CallPolicy struct {
+	// AutoReject declines every incoming call as soon as it rings.
+	AutoReject bool `json:"autoReject"`
+	// RejectMessage, if set, is sent to the caller after an automatic
+	// rejection.
+	RejectMessage string `json:"rejectMessage"`
+}
+
+// CallRecord is one incoming call. Status moves from "ringing" to
+// "accepted", "rejected", "missed" or "ended".
+type CallRecord struct {
+	ID          string `json:"id"`
+	From        string `json:"from"`
+	ContactName string `json:"contactName,omitempty"`
+	IsVideo     bool   `json:"isVideo"`
+	IsGroup     bool   `json:"isGroup"`
+	GroupID     string `json:"groupId,omitempty"`
+	Status      string `json:"status"`
+	AutoReject  bool   `json:"autoRejected,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Timestamp   string `json:"timestamp"`
+	EndedAt     string `json:"endedAt,omitempty"`
+}
+
+func GetCallPolicy(userId string) CallPolicy {
+	return LoadUser(userId).CallPolicy
+}
+
+func SetCallPolicy(userId string, policy CallPolicy) {
+	UpdateUser(userId, func(data *UserData) bool {
+		data.CallPolicy = policy
+		return true
+	})
+}
+
+// RecordCall adds a call to the log, dropping the oldest beyond
+// maxCallLog. WhatsApp can report the same call more than once (an offer
+// and an offer notice), so it returns false without logging if a call with
+// this ID is already there.
+func RecordCall(userId string, call CallRecord) bool {
+	added := false
+	UpdateUser(userId, func(data *UserData) bool {
+		for _, c := range data.Calls {
+			if c.ID == call.ID {
+				return false
+			}
+		}
+		data.Calls = append(data.Calls, call)
+		if len(data.Calls) > maxCallLog {
+			data.Calls = data.Calls[len(data.Calls)-maxCallLog:]
+		}
+		added = true
+		return true
+	})
+	return added
+}
+
+// UpdateCall applies fn to the logged call with the given ID. It returns
+// false if the call isn't in the log.
+func UpdateCall(userId string, id string, fn func(c *CallRecord)) (CallRecord, bool) {
+	var result CallRecord
+	found := false
+	UpdateUser(userId, func(data *UserData) bool {
+		for i := len(data.Calls) - 1; i >= 0; i-- {
+			if data.Calls[i].ID == id {
+				fn(&data.Calls[i])
+				result, found = data.Calls[i], true
+				return true
+			}
+		}
+		return false
+	})
+	return result, found
+}
+
+// FinishCall records that a logged call was answered ("accepted"),
+// declined ("rejected") or ended ("ended"). A call that ends while still
+// ringing is logged as missed, and a rejection is kept over the terminate
+// notice that follows it. It returns the updated call, and false if the
+// call isn't logged or didn't change.
+func FinishCall(userId string, id string, status string, reason string) (CallRecord, bool) {
+	changed := false
+	call, _ := UpdateCall(userId, id, func(c *CallRecord) {
+		if c.Status == "rejected" {
+			return
+		}
+		if status == "ended" && c.Status == "ringing" {
+			status = "missed"
+		}
+		c.Status = status
+		c.Reason = reason
+		if status != "accepted" {
+			c.EndedAt = time.Now().UTC().Format(time.RFC3339)
+		}
+		changed = true
+	})
+	return call, changed
+}
+
+// GetCalls returns the most recent calls, newest first.
+func GetCalls(userId string, limit int) []CallRecord {
+	calls := LoadUser(userId).Calls
+	result := make([]CallRecord, 0, len(calls))
+	for i := len(calls) - 1; i >= 0; i-- {
+		result = append(result, calls[i])
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
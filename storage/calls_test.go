@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordCallOncePerID(t *testing.T) {
+	userId := newTestUser(t).ID
+	if !RecordCall(userId, CallRecord{ID: "C1", Status: "ringing"}) {
+		t.Fatal("the first record of a call was refused")
+	}
+	// An offer notice for the same call
+	if RecordCall(userId, CallRecord{ID: "C1", Status: "ringing", IsVideo: true}) {
+		t.Error("the same call was logged twice")
+	}
+	if calls := GetCalls(userId, 0); len(calls) != 1 || calls[0].IsVideo {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestRecordCallKeepsLatest(t *testing.T) {
+	userId := newTestUser(t).ID
+	UpdateUser(userId, func(data *UserData) bool {
+		for i := 0; i < maxCallLog; i++ {
+			data.Calls = append(data.Calls, CallRecord{ID: fmt.Sprintf("OLD%d", i)})
+		}
+		return true
+	})
+	RecordCall(userId, CallRecord{ID: "NEWEST"})
+
+	calls := GetCalls(userId, 0)
+	if len(calls) != maxCallLog || calls[0].ID != "NEWEST" || calls[len(calls)-1].ID != "OLD1" {
+		t.Errorf("got %d calls from %s to %s", len(calls), calls[0].ID, calls[len(calls)-1].ID)
+	}
+	if got := GetCalls(userId, 3); len(got) != 3 {
+		t.Errorf("limit 3 returned %d calls", len(got))
+	}
+}
+
+func TestFinishCall(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		from       string
+		event      string
+		wantStatus string
+		changed    bool
+		ended      bool
+	}{
+		{"answered", "ringing", "accepted", "accepted", true, false},
+		{"unanswered", "ringing", "ended", "missed", true, true},
+		{"hung up after answering", "accepted", "ended", "ended", true, true},
+		{"declined on the phone", "ringing", "rejected", "rejected", true, true},
+		{"terminate after a rejection", "rejected", "ended", "rejected", false, false},
+		{"accept after a rejection", "rejected", "accepted", "rejected", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			userId := newTestUser(t).ID
+			RecordCall(userId, CallRecord{ID: "C1", Status: tc.from})
+
+			call, changed := FinishCall(userId, "C1", tc.event, "reason")
+			if changed != tc.changed || call.Status != tc.wantStatus {
+				t.Errorf("FinishCall = %q, %v; want %q, %v", call.Status, changed, tc.wantStatus, tc.changed)
+			}
+			if (call.EndedAt != "") != tc.ended {
+				t.Errorf("endedAt = %q", call.EndedAt)
+			}
+			if stored := GetCalls(userId, 1)[0]; stored.Status != tc.wantStatus {
+				t.Errorf("stored status = %q", stored.Status)
+			}
+		})
+	}
+
+	userId := newTestUser(t).ID
+	if _, changed := FinishCall(userId, "UNKNOWN", "ended", ""); changed {
+		t.Error("finished a call that isn't logged")
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// ChatState holds the per-chat flags kept in sync with WhatsApp: whether
+// the chat is archived, pinned or muted, when it was last read, and its
+// disappearing message timer.
+type ChatState struct {
+	Archived bool `json:"archived,omitempty"`
+	Pinned   bool `json:"pinned,omitempty"`
+	// MutedUntil is a Unix timestamp in milliseconds, or -1 for muted
+	// forever. Zero means not muted.
+	MutedUntil   int64  `json:"mutedUntil,omitempty"`
+	ReadAt       string `json:"readAt,omitempty"`
+	MarkedUnread bool   `json:"markedUnread,omitempty"`
+	// DisappearingTimer is the chat's disappearing message timer in
+	// seconds, or zero when off.
+	DisappearingTimer uint32 `json:"disappearingTimer,omitempty"`
+}
+
+func (s ChatState) Muted() bool {
+	return s.MutedUntil == -1 || s.MutedUntil > time.Now().UnixMilli()
+}
+
+// MessageChat returns the chat a logged message belongs to. Messages
+// logged before the chat field existed fall back to the peer address.
+func MessageChat(msg map[string]interface{}) string {
+	if chat, ok := msg["chat"].(string); ok && chat != "" {
+		return chat
+	}
+	if msg["type"] == "sent" {
+		to, _ := msg["to"].(string)
+		return to
+	}
+	from, _ := msg["from"].(string)
+	return from
+}
+
+func GetChatState(userId string, chat string) ChatState {
+	return LoadUser(userId).Chats[chat]
+}
+
+// UpdateChatState applies fn to the stored state of chat.
+func UpdateChatState(userId string, chat string, fn func(s *ChatState)) {
+	UpdateUser(userId, func(data *UserData) bool {
+		if data.Chats == nil {
+			data.Chats = make(map[string]ChatState)
+		}
+		s := data.Chats[chat]
+		fn(&s)
+		if s == (ChatState{}) {
+			delete(data.Chats, chat)
+		} else {
+			data.Chats[chat] = s
+		}
+		return true
+	})
+}
+
+// ClearChatMessages removes a chat's messages from the log. When remove is
+// set the chat's state is dropped as well, as for a deleted chat.
+func ClearChatMessages(userId string, chat string, remove bool) int {
+	var cleared []interface{}
+	UpdateUser(userId, func(data *UserData) bool {
+		kept := make([]interface{}, 0, len(data.Messages))
+		for _, m := range data.Messages {
+			if msg, ok := m.(map[string]interface{}); ok && MessageChat(msg) == chat {
+				cleared = append(cleared, m)
+				continue
+			}
+			kept = append(kept, m)
+		}
+		data.Messages = kept
+		if remove {
+			delete(data.Chats, chat)
+		}
+		return true
+	})
+	removeMessageContent(userId, cleared)
+	return len(cleared)
+}
+
+// ChatMessages returns the logged messages of one chat, oldest first.
+func ChatMessages(userId string, chat string) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, m := range LoadUser(userId).Messages {
+		if msg, ok := m.(map[string]interface{}); ok && MessageChat(msg) == chat {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// ListChats summarises the message log per chat, newest activity first with
+// pinned chats on top.
+func ListChats(userId string) []map[string]interface{} {
+	data := LoadUser(userId)
+
+	type summary struct {
+		chat   string
+		last   map[string]interface{}
+		unread int
+	}
+	byChat := make(map[string]*summary)
+	order := make([]*summary, 0)
+	for _, m := range data.Messages {
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		chat := MessageChat(msg)
+		if chat == "" {
+			continue
+		}
+		s, ok := byChat[chat]
+		if !ok {
+			s = &summary{chat: chat}
+			byChat[chat] = s
+			order = append(order, s)
+		}
+		s.last = msg
+		if msg["type"] == "received" {
+			ts, _ := msg["timestamp"].(string)
+			if readAt := data.Chats[chat].ReadAt; readAt == "" || ts > readAt {
+				s.unread++
+			}
+		}
+	}
+
+	result := make([]map[string]interface{}, 0, len(order))
+	for _, s := range order {
+		state := data.Chats[s.chat]
+		name := s.last["contactName"]
+		if isGroup, _ := s.last["isGroup"].(bool); isGroup {
+			name = s.last["groupName"]
+		}
+		var mutedUntil interface{}
+		if state.MutedUntil > 0 && state.Muted() {
+			mutedUntil = time.UnixMilli(state.MutedUntil).UTC().Format(time.RFC3339)
+		}
+		result = append(result, map[string]interface{}{
+			"chat":              s.chat,
+			"name":              name,
+			"isGroup":           s.last["isGroup"],
+			"lastMessage":       s.last,
+			"unreadCount":       s.unread,
+			"markedUnread":      state.MarkedUnread,
+			"archived":          state.Archived,
+			"pinned":            state.Pinned,
+			"muted":             state.Muted(),
+			"mutedUntil":        mutedUntil,
+			"disappearingTimer": state.DisappearingTimer,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		pi, pj := result[i]["pinned"].(bool), result[j]["pinned"].(bool)
+		if pi != pj {
+			return pi
+		}
+		ti, _ := result[i]["lastMessage"].(map[string]interface{})["timestamp"].(string)
+		tj, _ := result[j]["lastMessage"].(map[string]interface{})["timestamp"].(string)
+		return ti > tj
+	})
+	return result
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestClearChatMessagesKeepsConcurrentMessages(t *testing.T) {
+	userId := newTestUser(t).ID
+	for i := 0; i < 5; i++ {
+		PushToUserMessage(userId, map[string]interface{}{"id": fmt.Sprintf("OLD%d", i), "chat": "a@s.whatsapp.net"})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ClearChatMessages(userId, "a@s.whatsapp.net", true)
+	}()
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			PushToUserMessage(userId, map[string]interface{}{"id": fmt.Sprintf("NEW%d", i), "chat": "b@s.whatsapp.net"})
+		}(i)
+		go func() {
+			defer wg.Done()
+			UpdateChatState(userId, "b@s.whatsapp.net", func(s *ChatState) { s.Pinned = true })
+		}()
+	}
+	wg.Wait()
+
+	if got := len(ChatMessages(userId, "b@s.whatsapp.net")); got != 10 {
+		t.Errorf("%d of 10 concurrent messages survived clearing another chat", got)
+	}
+	if got := len(ChatMessages(userId, "a@s.whatsapp.net")); got != 0 {
+		t.Errorf("%d cleared messages are still logged", got)
+	}
+	if !GetChatState(userId, "b@s.whatsapp.net").Pinned {
+		t.Error("a concurrent chat state change was lost")
+	}
+}
+
+func TestUpdateChatStateRoundTrip(t *testing.T) {
+	const chat = "254712345678@s.whatsapp.net"
+	userId := newTestUser(t).ID
+	// Each case starts from the state the previous ones left behind
+	for _, tc := range []struct {
+		name   string
+		update func(s *ChatState)
+		want   ChatState
+	}{
+		{"archive", func(s *ChatState) { s.Archived = true }, ChatState{Archived: true}},
+		{"pin as well", func(s *ChatState) { s.Pinned = true }, ChatState{Archived: true, Pinned: true}},
+		{"mute forever", func(s *ChatState) { s.MutedUntil = -1 }, ChatState{Archived: true, Pinned: true, MutedUntil: -1}},
+		{"read with a timer", func(s *ChatState) {
+			s.ReadAt = "2026-01-02T03:04:05Z"
+			s.DisappearingTimer = 86400
+		}, ChatState{Archived: true, Pinned: true, MutedUntil: -1, ReadAt: "2026-01-02T03:04:05Z", DisappearingTimer: 86400}},
+		{"unarchive and unmute", func(s *ChatState) {
+			s.Archived = false
+			s.MutedUntil = 0
+		}, ChatState{Pinned: true, ReadAt: "2026-01-02T03:04:05Z", DisappearingTimer: 86400}},
+		{"reset", func(s *ChatState) { *s = ChatState{} }, ChatState{}},
+	} {
+		UpdateChatState(userId, chat, tc.update)
+		if got := GetChatState(userId, chat); got != tc.want {
+			t.Errorf("%s: state = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+	if _, ok := LoadUser(userId).Chats[chat]; ok {
+		t.Error("an empty chat state is still stored")
+	}
+	if GetChatState(userId, "other@s.whatsapp.net") != (ChatState{}) {
+		t.Error("another chat has state")
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ── Message content ──
+//
+// The log keeps a summary of each message; its full content (the encoded
+// protobuf, including media keys but not the media itself) is kept in a file
+// per message so it can be forwarded later. Content is removed along with
+// its log entry.
+
+var messageIdPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+func messageContentDir(userId string) (string, error) {
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usersDir, safeId, "content"), nil
+}
+
+func messageContentPath(userId string, id string) (string, bool) {
+	dir, err := messageContentDir(userId)
+	if err != nil || !messageIdPattern.MatchString(id) {
+		return "", false
+	}
+	return filepath.Join(dir, id+".pb"), true
+}
+
+// SaveMessageContent stores the encoded content of a logged message.
+func SaveMessageContent(userId string, id string, raw []byte) {
+	p, ok := messageContentPath(userId, id)
+	if !ok {
+		return
+	}
+	os.MkdirAll(filepath.Dir(p), 0755)
+	os.WriteFile(p, raw, 0644)
+}
+
+// LoadMessageContent returns the stored content of a message, if any.
+func LoadMessageContent(userId string, id string) ([]byte, bool) {
+	p, ok := messageContentPath(userId, id)
+	if !ok {
+		return nil, false
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// removeMessageContent deletes the stored content of the given log entries.
+func removeMessageContent(userId string, messages []interface{}) {
+	for _, m := range messages {
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := msg["id"].(string)
+		if p, ok := messageContentPath(userId, id); ok {
+			os.Remove(p)
+		}
+	}
+}
+
+func clearMessageContent(userId string) {
+	if dir, err := messageContentDir(userId); err == nil {
+		os.RemoveAll(dir)
+	}
+}
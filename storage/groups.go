@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	groupActivityMutex = &sync.Mutex{}
+	groupIdRegex       = regexp.MustCompile(`^[0-9-]+$`)
+)
+
+// GroupActivity is one entry in a group's activity log: a membership or
+// settings change seen by the instance.
+type GroupActivity struct {
+	Time         string                 `json:"time"`
+	GroupID      string                 `json:"groupId"`
+	Event        string                 `json:"event"`
+	Actor        string                 `json:"actor,omitempty"`
+	Participants []string               `json:"participants,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+}
+
+func groupActivityPath(userId string, groupId string) (string, error) {
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return "", err
+	}
+	if !groupIdRegex.MatchString(groupId) {
+		return "", errors.New("Invalid group ID")
+	}
+	return filepath.Join(usersDir, safeId, "groups", groupId+".jsonl"), nil
+}
+
+// RecordGroupActivity appends an entry to the group's activity log.
+func RecordGroupActivity(userId string, activity GroupActivity) {
+	if activity.Time == "" {
+		activity.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	p, err := groupActivityPath(userId, activity.GroupID)
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(activity)
+	if err != nil {
+		return
+	}
+
+	groupActivityMutex.Lock()
+	defer groupActivityMutex.Unlock()
+
+	os.MkdirAll(filepath.Dir(p), 0755)
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// GroupActivityLog returns the group's activity, newest first.
+func GroupActivityLog(userId string, groupId string, limit int, offset int) []GroupActivity {
+	result := make([]GroupActivity, 0)
+	p, err := groupActivityPath(userId, groupId)
+	if err != nil {
+		return result
+	}
+
+	groupActivityMutex.Lock()
+	f, err := os.Open(p)
+	if err != nil {
+		groupActivityMutex.Unlock()
+		return result
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var a GroupActivity
+		if json.Unmarshal(scanner.Bytes(), &a) == nil {
+			result = append(result, a)
+		}
+	}
+	f.Close()
+	groupActivityMutex.Unlock()
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	if offset > 0 {
+		if offset >= len(result) {
+			return make([]GroupActivity, 0)
+		}
+		result = result[offset:]
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
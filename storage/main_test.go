@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestMain points every store at a temporary directory so tests never touch
+// real server data.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wa-storage-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	dataDir = dir
+	usersDir = filepath.Join(dir, "users")
+	globalConfigPath = filepath.Join(dir, "global.json")
+	authPath = filepath.Join(dir, "auth.json")
+	orgsPath = filepath.Join(dir, "orgs.json")
+	systemAuditPath = filepath.Join(dir, "audit.jsonl")
+	bcryptRounds = bcrypt.MinCost
+	os.MkdirAll(usersDir, 0755)
+	EnsureGlobal()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var testUserSeq atomic.Int64
+
+// newTestUser registers an account with a unique email.
+func newTestUser(t *testing.T) *AuthUser {
+	t.Helper()
+	email := fmt.Sprintf("user%d@example.com", testUserSeq.Add(1))
+	user, err := Register(email, "password123")
+	if err != nil {
+		t.Fatalf("Register(%s): %v", email, err)
+	}
+	return user
+}
+
+// setUser applies fn to the stored account, for arranging test state.
+func setUser(t *testing.T, userId string, fn func(user *AuthUser)) {
+	t.Helper()
+	found := false
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID == userId {
+				fn(&auth.Users[i])
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		t.Fatalf("user %s not found", userId)
+	}
+}
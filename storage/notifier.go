@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OtpRecipient identifies the account a password-reset code is meant for.
+type OtpRecipient struct {
+	UserID string
+	Email  string
+}
+
+// OtpNotifier delivers password-reset codes to their owner.
+type OtpNotifier interface {
+	SendResetOtp(to OtpRecipient, otp string, expires time.Time) error
+}
+
+// StdoutNotifier prints reset codes to the server console. It is the
+// default and is meant for local development.
+type StdoutNotifier struct{}
+
+func (StdoutNotifier) SendResetOtp(to OtpRecipient, otp string, expires time.Time) error {
+	fmt.Printf("\n🔑 Password reset OTP for %s: %s\n", to.Email, otp)
+	fmt.Printf("   Valid for %d minutes.\n\n", int(time.Until(expires).Round(time.Minute).Minutes()))
+	return nil
+}
+
+var (
+	otpNotifier      OtpNotifier = StdoutNotifier{}
+	otpNotifierMutex             = &sync.RWMutex{}
+)
+
+// SetOtpNotifier replaces the notifier used by ForgotPassword.
+func SetOtpNotifier(n OtpNotifier) {
+	otpNotifierMutex.Lock()
+	defer otpNotifierMutex.Unlock()
+	otpNotifier = n
+}
+
+func getOtpNotifier() OtpNotifier {
+	otpNotifierMutex.RLock()
+	defer otpNotifierMutex.RUnlock()
+	return otpNotifier
+}
@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	orgsPath      = filepath.Join(dataDir, "orgs.json")
+	orgsMutex     = &sync.RWMutex{}
+	invitationTTL = 7 * 24 * time.Hour
+)
+
+// Organization roles, from least to most privileged.
+const (
+	OrgRoleViewer = "viewer"
+	OrgRoleAgent  = "agent"
+	OrgRoleAdmin  = "admin"
+	OrgRoleOwner  = "owner"
+)
+
+var orgRoleRank = map[string]int{
+	OrgRoleViewer: 1,
+	OrgRoleAgent:  2,
+	OrgRoleAdmin:  3,
+	OrgRoleOwner:  4,
+}
+
+// OrgRoleAtLeast reports whether role grants at least the privileges of min.
+func OrgRoleAtLeast(role string, min string) bool {
+	return orgRoleRank[role] >= orgRoleRank[min]
+}
+
+// Organization shares one WhatsApp instance between its members. The
+// organization's ID is used as the instance ID, so its WhatsApp session and
+// bot data live in data/users/<orgId> just like a personal instance.
+type Organization struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	CreatedAt   string          `json:"createdAt"`
+	Members     []OrgMember     `json:"members"`
+	Invitations []OrgInvitation `json:"invitations"`
+}
+
+type OrgMember struct {
+	UserID   string `json:"userId"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joinedAt"`
+}
+
+type OrgInvitation struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	TokenHash string `json:"tokenHash"`
+	InvitedBy string `json:"invitedBy"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type OrgData struct {
+	Organizations []Organization `json:"organizations"`
+}
+
+// OrgSummary is an organization as seen by one of its members.
+type OrgSummary struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	MemberCount int    `json:"memberCount"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// ── Helpers ──
+
+func readOrgsFile() OrgData {
+	var data OrgData
+	data.Organizations = make([]Organization, 0)
+
+	bytes, err := os.ReadFile(orgsPath)
+	if err != nil {
+		return data
+	}
+	json.Unmarshal(bytes, &data)
+	if data.Organizations == nil {
+		data.Organizations = make([]Organization, 0)
+	}
+	return data
+}
+
+func loadOrgs() OrgData {
+	orgsMutex.RLock()
+	defer orgsMutex.RUnlock()
+	return readOrgsFile()
+}
+
+// updateOrgs runs fn under the write lock and saves when it reports a change.
+func updateOrgs(fn func(data *OrgData) bool) {
+	orgsMutex.Lock()
+	defer orgsMutex.Unlock()
+
+	data := readOrgsFile()
+	if fn(&data) {
+		os.MkdirAll(filepath.Dir(orgsPath), 0755)
+		bytes, _ := json.MarshalIndent(data, "", "  ")
+		os.WriteFile(orgsPath, bytes, 0644)
+	}
+}
+
+func (o *Organization) member(userId string) *OrgMember {
+	for i := range o.Members {
+		if o.Members[i].UserID == userId {
+			return &o.Members[i]
+		}
+	}
+	return nil
+}
+
+func validOrgRole(role string) bool {
+	_, ok := orgRoleRank[role]
+	return ok
+}
+
+// modifyOrg applies fn to the organization with orgId.
+func modifyOrg(orgId string, fn func(org *Organization) error) (*Organization, error) {
+	var result *Organization
+	var modifyErr error
+	updateOrgs(func(data *OrgData) bool {
+		for i := range data.Organizations {
+			if data.Organizations[i].ID != orgId {
+				continue
+			}
+			if modifyErr = fn(&data.Organizations[i]); modifyErr != nil {
+				return false
+			}
+			copied := data.Organizations[i]
+			result = &copied
+			return true
+		}
+		modifyErr = errors.New("Organization not found")
+		return false
+	})
+	return result, modifyErr
+}
+
+// ── Public API ──
+
+func GetOrganization(orgId string) *Organization {
+	data := loadOrgs()
+	for i := range data.Organizations {
+		if data.Organizations[i].ID == orgId {
+			return &data.Organizations[i]
+		}
+	}
+	return nil
+}
+
+// OrgRole returns the user's role in the organization, or "" if they aren't
+// a member.
+func OrgRole(orgId string, userId string) string {
+	org := GetOrganization(orgId)
+	if org == nil {
+		return ""
+	}
+	if m := org.member(userId); m != nil {
+		return m.Role
+	}
+	return ""
+}
+
+func ListUserOrganizations(userId string) []OrgSummary {
+	result := make([]OrgSummary, 0)
+	for _, org := range loadOrgs().Organizations {
+		if m := org.member(userId); m != nil {
+			result = append(result, OrgSummary{
+				ID:          org.ID,
+				Name:        org.Name,
+				Role:        m.Role,
+				MemberCount: len(org.Members),
+				CreatedAt:   org.CreatedAt,
+			})
+		}
+	}
+	return result
+}
+
+func CreateOrganization(name string, owner *AuthUser) (*Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("Organization name is required")
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	org := Organization{
+		ID:        generateUUID(),
+		Name:      name,
+		CreatedAt: now,
+		Members: []OrgMember{{
+			UserID:   owner.ID,
+			Email:    owner.Email,
+			Role:     OrgRoleOwner,
+			JoinedAt: now,
+		}},
+		Invitations: make([]OrgInvitation, 0),
+	}
+	updateOrgs(func(data *OrgData) bool {
+		data.Organizations = append(data.Organizations, org)
+		return true
+	})
+	InitUser(org.ID)
+	return &org, nil
+}
+
+func RenameOrganization(orgId string, name string) (*Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("Organization name is required")
+	}
+	return modifyOrg(orgId, func(org *Organization) error {
+		org.Name = name
+		return nil
+	})
+}
+
+// DeleteOrganization removes the organization and its instance data. The
+// caller must shut down the WhatsApp instance first.
+func DeleteOrganization(orgId string) error {
+	found := false
+	updateOrgs(func(data *OrgData) bool {
+		for i := range data.Organizations {
+			if data.Organizations[i].ID == orgId {
+				data.Organizations = append(data.Organizations[:i], data.Organizations[i+1:]...)
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		return errors.New("Organization not found")
+	}
+	return DeleteUserData(orgId)
+}
+
+// InviteToOrganization creates an invitation and returns the raw token the
+// invitee uses to accept it. Only its hash is stored.
+func InviteToOrganization(orgId string, email string, role string, invitedBy string) (string, *OrgInvitation, error) {
+	normalized := strings.TrimSpace(strings.ToLower(email))
+	if !emailRegex.MatchString(normalized) {
+		return "", nil, errors.New("Please enter a valid email address")
+	}
+	if !validOrgRole(role) || role == OrgRoleOwner {
+		return "", nil, errors.New("Role must be admin, agent or viewer")
+	}
+
+	token := generateToken()
+	now := time.Now()
+	invite := OrgInvitation{
+		ID:        generateUUID(),
+		Email:     normalized,
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: invitedBy,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ExpiresAt: now.Add(invitationTTL).UnixMilli(),
+	}
+	_, err := modifyOrg(orgId, func(org *Organization) error {
+		for _, m := range org.Members {
+			if m.Email == normalized {
+				return errors.New("That user is already a member")
+			}
+		}
+		kept := make([]OrgInvitation, 0, len(org.Invitations)+1)
+		for _, inv := range org.Invitations {
+			// A new invitation replaces any earlier one for the same email
+			if inv.Email != normalized && inv.ExpiresAt > now.UnixMilli() {
+				kept = append(kept, inv)
+			}
+		}
+		org.Invitations = append(kept, invite)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &invite, nil
+}
+
+func RevokeInvitation(orgId string, inviteId string) error {
+	_, err := modifyOrg(orgId, func(org *Organization) error {
+		for i, inv := range org.Invitations {
+			if inv.ID == inviteId {
+				org.Invitations = append(org.Invitations[:i], org.Invitations[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("Invitation not found")
+	})
+	return err
+}
+
+// AcceptInvitation adds user to the organization the token was issued for.
+// The invitation must have been sent to the user's email.
+func AcceptInvitation(token string, user *AuthUser) (*Organization, error) {
+	hash := hashToken(token)
+	now := time.Now().UnixMilli()
+
+	var result *Organization
+	var acceptErr = errors.New("Invitation not found or expired")
+	updateOrgs(func(data *OrgData) bool {
+		for oi := range data.Organizations {
+			org := &data.Organizations[oi]
+			for ii, inv := range org.Invitations {
+				if !hmac.Equal([]byte(inv.TokenHash), []byte(hash)) {
+					continue
+				}
+				if inv.ExpiresAt <= now {
+					return false
+				}
+				if inv.Email != user.Email {
+					acceptErr = errors.New("This invitation was sent to a different email address")
+					return false
+				}
+				org.Invitations = append(org.Invitations[:ii], org.Invitations[ii+1:]...)
+				if org.member(user.ID) == nil {
+					org.Members = append(org.Members, OrgMember{
+						UserID:   user.ID,
+						Email:    user.Email,
+						Role:     inv.Role,
+						JoinedAt: time.Now().UTC().Format(time.RFC3339),
+					})
+				}
+				copied := *org
+				result = &copied
+				acceptErr = nil
+				return true
+			}
+		}
+		return false
+	})
+	return result, acceptErr
+}
+
+// SetMemberRole changes a member's role. Ownership can only move through
+// TransferOwnership.
+func SetMemberRole(orgId string, userId string, role string) (*Organization, error) {
+	if !validOrgRole(role) || role == OrgRoleOwner {
+		return nil, errors.New("Role must be admin, agent or viewer")
+	}
+	return modifyOrg(orgId, func(org *Organization) error {
+		m := org.member(userId)
+		if m == nil {
+			return errors.New("Member not found")
+		}
+		if m.Role == OrgRoleOwner {
+			return errors.New("Transfer ownership before changing the owner's role")
+		}
+		m.Role = role
+		return nil
+	})
+}
+
+func RemoveMember(orgId string, userId string) (*Organization, error) {
+	return modifyOrg(orgId, func(org *Organization) error {
+		for i, m := range org.Members {
+			if m.UserID != userId {
+				continue
+			}
+			if m.Role == OrgRoleOwner {
+				return errors.New("The owner can't leave — transfer ownership or delete the organization")
+			}
+			org.Members = append(org.Members[:i], org.Members[i+1:]...)
+			return nil
+		}
+		return errors.New("Member not found")
+	})
+}
+
+func TransferOwnership(orgId string, fromUserId string, toUserId string) (*Organization, error) {
+	return modifyOrg(orgId, func(org *Organization) error {
+		from, to := org.member(fromUserId), org.member(toUserId)
+		if to == nil {
+			return errors.New("Member not found")
+		}
+		if from == nil || from.Role != OrgRoleOwner {
+			return errors.New("Only the owner can transfer ownership")
+		}
+		from.Role = OrgRoleAdmin
+		to.Role = OrgRoleOwner
+		return nil
+	})
+}
+
+// OwnershipTransfer records an organization that got a new owner because
+// its owner's account was deleted.
+type OwnershipTransfer struct {
+	OrgID        string
+	UserID       string
+	PreviousRole string
+}
+
+// RemoveUserFromOrganizations drops a deleted account from every
+// organization. Ownership passes to the most senior member with the highest
+// remaining role, and each such handover is returned so the caller can audit
+// it. Organizations left empty are returned so the caller can delete them
+// along with their instances.
+func RemoveUserFromOrganizations(userId string) (orphaned []string, transfers []OwnershipTransfer) {
+	orphaned = make([]string, 0)
+	updateOrgs(func(data *OrgData) bool {
+		changed := false
+		for oi := range data.Organizations {
+			org := &data.Organizations[oi]
+			for mi, m := range org.Members {
+				if m.UserID != userId {
+					continue
+				}
+				org.Members = append(org.Members[:mi], org.Members[mi+1:]...)
+				changed = true
+				if len(org.Members) == 0 {
+					orphaned = append(orphaned, org.ID)
+				} else if m.Role == OrgRoleOwner {
+					heir := 0
+					for i := range org.Members {
+						if orgRoleRank[org.Members[i].Role] > orgRoleRank[org.Members[heir].Role] {
+							heir = i
+						}
+					}
+					transfers = append(transfers, OwnershipTransfer{
+						OrgID:        org.ID,
+						UserID:       org.Members[heir].UserID,
+						PreviousRole: org.Members[heir].Role,
+					})
+					org.Members[heir].Role = OrgRoleOwner
+				}
+				break
+			}
+		}
+		return changed
+	})
+	return orphaned, transfers
+}
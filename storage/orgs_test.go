@@ -0,0 +1,98 @@
+package storage
+
+import "testing"
+
+func TestOrgRoleAtLeast(t *testing.T) {
+	roles := []string{OrgRoleViewer, OrgRoleAgent, OrgRoleAdmin, OrgRoleOwner}
+	for i, role := range roles {
+		for j, min := range roles {
+			if got := OrgRoleAtLeast(role, min); got != (i >= j) {
+				t.Errorf("OrgRoleAtLeast(%s, %s) = %v", role, min, got)
+			}
+		}
+	}
+	for _, role := range []string{"", "superuser"} {
+		if OrgRoleAtLeast(role, OrgRoleViewer) {
+			t.Errorf("unknown role %q counts as a member", role)
+		}
+	}
+}
+
+// addTestMember invites a new account to org with role and accepts.
+func addTestMember(t *testing.T, org *Organization, role string) *AuthUser {
+	t.Helper()
+	user := newTestUser(t)
+	token, _, err := InviteToOrganization(org.ID, user.Email, role, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptInvitation(token, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRemoveUserFromOrganizationsPicksHeir(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		members []string // roles, in joining order
+		heir    int      // index into members
+	}{
+		{"admin over earlier members", []string{OrgRoleViewer, OrgRoleAgent, OrgRoleAdmin}, 2},
+		{"agent over an earlier viewer", []string{OrgRoleViewer, OrgRoleAgent}, 1},
+		{"earliest of equal roles", []string{OrgRoleAgent, OrgRoleAgent}, 0},
+		{"viewer when nobody else is left", []string{OrgRoleViewer}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			owner := newTestUser(t)
+			org, err := CreateOrganization("Org", owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var members []*AuthUser
+			for _, role := range tc.members {
+				members = append(members, addTestMember(t, org, role))
+			}
+
+			orphaned, transfers := RemoveUserFromOrganizations(owner.ID)
+			if len(orphaned) != 0 {
+				t.Errorf("orphaned = %v", orphaned)
+			}
+			heir := members[tc.heir]
+			want := OwnershipTransfer{OrgID: org.ID, UserID: heir.ID, PreviousRole: tc.members[tc.heir]}
+			if len(transfers) != 1 || transfers[0] != want {
+				t.Errorf("transfers = %+v, want %+v", transfers, want)
+			}
+			if role := OrgRole(org.ID, heir.ID); role != OrgRoleOwner {
+				t.Errorf("heir's role = %q", role)
+			}
+			if role := OrgRole(org.ID, owner.ID); role != "" {
+				t.Errorf("the deleted owner is still a %s", role)
+			}
+		})
+	}
+}
+
+func TestRemoveUserFromOrganizationsOrphans(t *testing.T) {
+	owner := newTestUser(t)
+	org, err := CreateOrganization("Solo", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := CreateOrganization("Shared", newTestUser(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestMember(t, shared, OrgRoleAgent)
+	agent := addTestMember(t, shared, OrgRoleAgent)
+
+	orphaned, transfers := RemoveUserFromOrganizations(owner.ID)
+	if len(orphaned) != 1 || orphaned[0] != org.ID || len(transfers) != 0 {
+		t.Errorf("orphaned %v, transfers %+v; want only %s orphaned", orphaned, transfers, org.ID)
+	}
+
+	// A member who isn't the owner leaves without a handover
+	if _, transfers := RemoveUserFromOrganizations(agent.ID); len(transfers) != 0 {
+		t.Errorf("removing an agent transferred ownership: %+v", transfers)
+	}
+}
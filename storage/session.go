@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	sessionTTL         = 7 * 24 * time.Hour
+	sessionTouchPeriod = time.Minute
+	maxSessionsPerUser = 20
+)
+
+type AuthSession struct {
+	ID         string `json:"id"`
+	TokenHash  string `json:"tokenHash"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// SessionInfo is the public view of a session, without the token hash.
+type SessionInfo struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	Current    bool   `json:"current"`
+}
+
+// ── Helpers ──
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func formatMillis(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+// pruneSessions drops expired sessions and, if the user still has too many,
+// the least recently used ones.
+func pruneSessions(sessions []AuthSession, now int64) []AuthSession {
+	live := make([]AuthSession, 0, len(sessions))
+	for _, s := range sessions {
+		if s.ExpiresAt > now {
+			live = append(live, s)
+		}
+	}
+	for len(live) > maxSessionsPerUser {
+		oldest := 0
+		for i := range live {
+			if live[i].LastSeenAt < live[oldest].LastSeenAt {
+				oldest = i
+			}
+		}
+		live = append(live[:oldest], live[oldest+1:]...)
+	}
+	return live
+}
+
+// findSession locates the session matching token. It returns the indexes of
+// the owning user and session, or -1s when no live session matches.
+func findSession(auth *AuthData, token string, now int64) (int, int) {
+	if token == "" {
+		return -1, -1
+	}
+	want := []byte(hashToken(token))
+	for ui := range auth.Users {
+		for si, s := range auth.Users[ui].Sessions {
+			if subtle.ConstantTimeCompare(want, []byte(s.TokenHash)) == 1 && s.ExpiresAt > now {
+				return ui, si
+			}
+		}
+	}
+	return -1, -1
+}
+
+// ── Public API ──
+
+// CreateSession starts a new login session for the user and returns the raw
+// bearer token. Only its hash is persisted.
+func CreateSession(userId, userAgent, ip string) (string, *AuthSession, error) {
+	token := generateToken()
+	now := time.Now()
+	session := AuthSession{
+		ID:         generateUUID(),
+		TokenHash:  hashToken(token),
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now.UTC().Format(time.RFC3339),
+		LastSeenAt: now.UnixMilli(),
+		ExpiresAt:  now.Add(sessionTTL).UnixMilli(),
+	}
+
+	found := false
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID == userId {
+				user := &auth.Users[i]
+				user.Sessions = pruneSessions(append(user.Sessions, session), now.UnixMilli())
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		return "", nil, errors.New("User not found")
+	}
+	return token, &session, nil
+}
+
+// FindUserBySession resolves a bearer token to its user and session. Each
+// successful lookup slides the session's expiry forward; the file is only
+// rewritten once per sessionTouchPeriod to keep request overhead low.
+func FindUserBySession(token string, ip string) (*AuthUser, *AuthSession) {
+	now := time.Now().UnixMilli()
+
+	auth := loadAuth()
+	ui, si := findSession(&auth, token, now)
+	if ui == -1 {
+		return nil, nil
+	}
+	user := auth.Users[ui]
+	session := user.Sessions[si]
+	if user.Disabled {
+		return nil, nil
+	}
+
+	if now-session.LastSeenAt < sessionTouchPeriod.Milliseconds() {
+		return &user, &session
+	}
+
+	updateAuth(func(auth *AuthData) bool {
+		ui, si := findSession(auth, token, now)
+		if ui == -1 {
+			return false
+		}
+		s := &auth.Users[ui].Sessions[si]
+		s.LastSeenAt = now
+		s.ExpiresAt = now + sessionTTL.Milliseconds()
+		if ip != "" {
+			s.IP = ip
+		}
+		session = *s
+		return true
+	})
+	return &user, &session
+}
+
+// RotateSession replaces the token of the session identified by token with a
+// fresh one and extends its expiry. The old token stops working immediately.
+func RotateSession(token string) (string, *AuthSession, error) {
+	newToken := generateToken()
+	now := time.Now().UnixMilli()
+
+	var rotated *AuthSession
+	updateAuth(func(auth *AuthData) bool {
+		ui, si := findSession(auth, token, now)
+		if ui == -1 {
+			return false
+		}
+		s := &auth.Users[ui].Sessions[si]
+		s.TokenHash = hashToken(newToken)
+		s.LastSeenAt = now
+		s.ExpiresAt = now + sessionTTL.Milliseconds()
+		copied := *s
+		rotated = &copied
+		return true
+	})
+	if rotated == nil {
+		return "", nil, errors.New("Session not found or expired")
+	}
+	return newToken, rotated, nil
+}
+
+// ListSessions returns the user's live sessions, marking the one with
+// currentId as current.
+func ListSessions(userId string, currentId string) []SessionInfo {
+	now := time.Now().UnixMilli()
+	result := make([]SessionInfo, 0)
+
+	auth := loadAuth()
+	for _, u := range auth.Users {
+		if u.ID != userId {
+			continue
+		}
+		for _, s := range u.Sessions {
+			if s.ExpiresAt <= now {
+				continue
+			}
+			result = append(result, SessionInfo{
+				ID:         s.ID,
+				UserAgent:  s.UserAgent,
+				IP:         s.IP,
+				CreatedAt:  s.CreatedAt,
+				LastSeenAt: formatMillis(s.LastSeenAt),
+				ExpiresAt:  formatMillis(s.ExpiresAt),
+				Current:    s.ID == currentId,
+			})
+		}
+	}
+	return result
+}
+
+// RevokeSession ends a single session belonging to the user.
+func RevokeSession(userId string, sessionId string) error {
+	removed := false
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID != userId {
+				continue
+			}
+			kept := make([]AuthSession, 0, len(auth.Users[i].Sessions))
+			for _, s := range auth.Users[i].Sessions {
+				if s.ID == sessionId {
+					removed = true
+					continue
+				}
+				kept = append(kept, s)
+			}
+			auth.Users[i].Sessions = kept
+		}
+		return removed
+	})
+	if !removed {
+		return errors.New("Session not found")
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of the user except exceptId (pass an
+// empty string to end them all) and returns how many were removed.
+func RevokeAllSessions(userId string, exceptId string) int {
+	count := 0
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID != userId {
+				continue
+			}
+			kept := make([]AuthSession, 0, 1)
+			for _, s := range auth.Users[i].Sessions {
+				if exceptId != "" && s.ID == exceptId {
+					kept = append(kept, s)
+					continue
+				}
+				count++
+			}
+			auth.Users[i].Sessions = kept
+		}
+		return count > 0
+	})
+	return count
+}
+
+// RevokeSessionByToken ends the session the token belongs to, if any.
+func RevokeSessionByToken(token string) {
+	now := time.Now().UnixMilli()
+	updateAuth(func(auth *AuthData) bool {
+		ui, si := findSession(auth, token, now)
+		if ui == -1 {
+			return false
+		}
+		sessions := auth.Users[ui].Sessions
+		auth.Users[ui].Sessions = append(sessions[:si], sessions[si+1:]...)
+		return true
+	})
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindUserBySession(t *testing.T) {
+	user := newTestUser(t)
+	token, session, err := CreateSession(user.ID, "test-agent", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, s := FindUserBySession(token, "")
+	if found == nil || found.ID != user.ID || s.ID != session.ID {
+		t.Fatalf("FindUserBySession = %v, %v; want user %s session %s", found, s, user.ID, session.ID)
+	}
+	if found, _ := FindUserBySession(token+"x", ""); found != nil {
+		t.Error("a wrong token resolved to a user")
+	}
+	if found, _ := FindUserBySession("", ""); found != nil {
+		t.Error("an empty token resolved to a user")
+	}
+}
+
+func TestSessionSlidingExpiry(t *testing.T) {
+	user := newTestUser(t)
+	token, session, err := CreateSession(user.ID, "", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the touch period the session is left as it is
+	if _, s := FindUserBySession(token, "10.0.0.2"); s.ExpiresAt != session.ExpiresAt || s.IP != "10.0.0.1" {
+		t.Errorf("session was touched within the touch period: %+v", s)
+	}
+
+	// Once the touch period has passed, use slides the expiry forward
+	now := time.Now()
+	setUser(t, user.ID, func(u *AuthUser) {
+		u.Sessions[0].LastSeenAt = now.Add(-2 * sessionTouchPeriod).UnixMilli()
+		u.Sessions[0].ExpiresAt = now.Add(time.Hour).UnixMilli()
+	})
+	_, s := FindUserBySession(token, "10.0.0.2")
+	if s == nil {
+		t.Fatal("session not found")
+	}
+	if want := now.Add(sessionTTL).UnixMilli(); s.ExpiresAt < want {
+		t.Errorf("ExpiresAt = %d, want at least %d", s.ExpiresAt, want)
+	}
+	if s.IP != "10.0.0.2" {
+		t.Errorf("IP = %q, want the latest address", s.IP)
+	}
+	if stored := FindUserByID(user.ID).Sessions[0]; stored.ExpiresAt != s.ExpiresAt {
+		t.Errorf("extended expiry wasn't persisted: %d != %d", stored.ExpiresAt, s.ExpiresAt)
+	}
+
+	// Expired sessions don't resolve, and aren't revived by use
+	setUser(t, user.ID, func(u *AuthUser) { u.Sessions[0].ExpiresAt = now.Add(-time.Second).UnixMilli() })
+	if found, _ := FindUserBySession(token, ""); found != nil {
+		t.Error("an expired session still resolved")
+	}
+}
+
+func TestSessionOfDisabledUser(t *testing.T) {
+	user := newTestUser(t)
+	token, _, err := CreateSession(user.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setUser(t, user.ID, func(u *AuthUser) { u.Disabled = true })
+	if found, _ := FindUserBySession(token, ""); found != nil {
+		t.Error("a disabled account's session still resolved")
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	user := newTestUser(t)
+	token, session, err := CreateSession(user.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, rotated, err := RotateSession(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken == token {
+		t.Fatal("rotation returned the same token")
+	}
+	if rotated.ID != session.ID {
+		t.Errorf("rotated session ID = %s, want %s", rotated.ID, session.ID)
+	}
+	if found, _ := FindUserBySession(token, ""); found != nil {
+		t.Error("the old token still works after rotation")
+	}
+	if found, _ := FindUserBySession(newToken, ""); found == nil || found.ID != user.ID {
+		t.Error("the new token doesn't resolve to the user")
+	}
+	if _, _, err := RotateSession(token); err == nil {
+		t.Error("rotating an already rotated token succeeded")
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	user := newTestUser(t)
+	first, keep, _ := CreateSession(user.ID, "", "")
+	second, _, _ := CreateSession(user.ID, "", "")
+	third, _, _ := CreateSession(user.ID, "", "")
+
+	RevokeSessionByToken(third)
+	if found, _ := FindUserBySession(third, ""); found != nil {
+		t.Error("a revoked token still works")
+	}
+
+	if n := RevokeAllSessions(user.ID, keep.ID); n != 1 {
+		t.Errorf("RevokeAllSessions removed %d sessions, want 1", n)
+	}
+	if found, _ := FindUserBySession(first, ""); found == nil {
+		t.Error("the kept session was revoked")
+	}
+	if found, _ := FindUserBySession(second, ""); found != nil {
+		t.Error("a revoked session still works")
+	}
+
+	if err := RevokeSession(user.ID, keep.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeSession(user.ID, keep.ID); err == nil {
+		t.Error("revoking a missing session succeeded")
+	}
+}
+
+func TestPruneSessions(t *testing.T) {
+	now := time.Now().UnixMilli()
+	var sessions []AuthSession
+	sessions = append(sessions, AuthSession{ID: "expired", LastSeenAt: now, ExpiresAt: now - 1})
+	for i := 0; i < maxSessionsPerUser+1; i++ {
+		sessions = append(sessions, AuthSession{ID: string(rune('a' + i)), LastSeenAt: now + int64(i), ExpiresAt: now + 1000})
+	}
+
+	live := pruneSessions(sessions, now)
+	if len(live) != maxSessionsPerUser {
+		t.Fatalf("kept %d sessions, want %d", len(live), maxSessionsPerUser)
+	}
+	for _, s := range live {
+		if s.ID == "expired" || s.ID == "a" {
+			t.Errorf("kept %q, which should have been pruned", s.ID)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var statusMutex = &sync.Mutex{}
+
+// StatusLifetime is how long WhatsApp keeps a status update visible.
+const StatusLifetime = 24 * time.Hour
+
+// statusLogCompactSize is the size above which the status log is rewritten
+// without expired entries.
+const statusLogCompactSize = 2 << 20
+
+// StatusUpdate is a status posted by a contact, or by the instance itself
+// when FromMe is set. Media isn't kept, only its description.
+type StatusUpdate struct {
+	ID              string `json:"id"`
+	From            string `json:"from"`
+	FromMe          bool   `json:"fromMe"`
+	ContactName     string `json:"contactName,omitempty"`
+	Type            string `json:"type"`
+	Text            string `json:"text,omitempty"`
+	Caption         string `json:"caption,omitempty"`
+	Mimetype        string `json:"mimetype,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	Font            *int32 `json:"font,omitempty"`
+	Timestamp       string `json:"timestamp"`
+}
+
+func statusLogPath(userId string) (string, error) {
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usersDir, safeId, "status.jsonl"), nil
+}
+
+// RecordStatus appends a status update to the instance's status log.
+func RecordStatus(userId string, status StatusUpdate) {
+	if status.Timestamp == "" {
+		status.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	p, err := statusLogPath(userId)
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	os.MkdirAll(filepath.Dir(p), 0755)
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	f.Write(append(line, '\n'))
+	info, err := f.Stat()
+	f.Close()
+	if err == nil && info.Size() > statusLogCompactSize {
+		compactStatusLog(p)
+	}
+}
+
+func readStatusLog(p string) []StatusUpdate {
+	var result []StatusUpdate
+	f, err := os.Open(p)
+	if err != nil {
+		return result
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var s StatusUpdate
+		if json.Unmarshal(scanner.Bytes(), &s) == nil {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// compactStatusLog drops expired statuses. Callers hold statusMutex.
+func compactStatusLog(p string) {
+	cutoff := time.Now().Add(-StatusLifetime).UTC().Format(time.RFC3339)
+	var buf []byte
+	for _, s := range readStatusLog(p) {
+		if s.Timestamp < cutoff {
+			continue
+		}
+		line, err := json.Marshal(s)
+		if err != nil {
+			continue
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, p)
+}
+
+// RecentStatuses returns the statuses posted in the last 24 hours, newest
+// first. An empty from matches every sender.
+func RecentStatuses(userId string, from string, limit int) []StatusUpdate {
+	result := make([]StatusUpdate, 0)
+	p, err := statusLogPath(userId)
+	if err != nil {
+		return result
+	}
+
+	statusMutex.Lock()
+	entries := readStatusLog(p)
+	statusMutex.Unlock()
+
+	// The log is in arrival order, which history syncs can leave out of
+	// timestamp order, so every entry is checked
+	cutoff := time.Now().Add(-StatusLifetime).UTC().Format(time.RFC3339)
+	for _, s := range entries {
+		if s.Timestamp < cutoff || (from != "" && s.From != from) {
+			continue
+		}
+		result = append(result, s)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp > result[j].Timestamp })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func statusAt(id string, from string, age time.Duration) StatusUpdate {
+	return StatusUpdate{ID: id, From: from, Type: "text", Timestamp: time.Now().Add(-age).UTC().Format(time.RFC3339)}
+}
+
+func TestStatusExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		age  time.Duration
+		kept bool
+	}{
+		{0, true},
+		{time.Hour, true},
+		{StatusLifetime - time.Minute, true},
+		{StatusLifetime + time.Minute, false},
+		{2 * StatusLifetime, false},
+	} {
+		userId := newTestUser(t).ID
+		RecordStatus(userId, statusAt("S1", "a", tc.age))
+		want := 0
+		if tc.kept {
+			want = 1
+		}
+		if got := len(RecentStatuses(userId, "", 0)); got != want {
+			t.Errorf("status %v old: listed %d times, want %d", tc.age, got, want)
+		}
+	}
+}
+
+func TestCompactStatusLog(t *testing.T) {
+	userId := newTestUser(t).ID
+	for _, s := range []StatusUpdate{
+		statusAt("OLD1", "a", StatusLifetime+time.Hour),
+		statusAt("NEW1", "a", StatusLifetime-time.Minute),
+		statusAt("OLD2", "b", StatusLifetime+time.Minute),
+		statusAt("NEW2", "b", time.Minute),
+	} {
+		RecordStatus(userId, s)
+	}
+	p, _ := statusLogPath(userId)
+
+	statusMutex.Lock()
+	compactStatusLog(p)
+	entries := readStatusLog(p)
+	statusMutex.Unlock()
+
+	if len(entries) != 2 || entries[0].ID != "NEW1" || entries[1].ID != "NEW2" {
+		t.Errorf("after compaction: %+v, want NEW1 and NEW2 in order", entries)
+	}
+	if _, err := os.Stat(p + ".tmp"); !os.IsNotExist(err) {
+		t.Error("compaction left its temporary file behind")
+	}
+}
+
+func TestRecentStatuses(t *testing.T) {
+	userId := newTestUser(t).ID
+	// Arrival order differs from timestamp order, as after a history sync
+	for _, s := range []StatusUpdate{
+		statusAt("A2", "a", 2*time.Hour),
+		statusAt("B1", "b", time.Hour),
+		statusAt("EXPIRED", "a", StatusLifetime+time.Hour),
+		statusAt("A1", "a", 30*time.Minute),
+		statusAt("A3", "a", 3*time.Hour),
+	} {
+		RecordStatus(userId, s)
+	}
+
+	for _, tc := range []struct {
+		from  string
+		limit int
+		want  []string
+	}{
+		{"", 0, []string{"A1", "B1", "A2", "A3"}},
+		{"", 2, []string{"A1", "B1"}},
+		{"a", 0, []string{"A1", "A2", "A3"}},
+		{"a", 1, []string{"A1"}},
+		{"b", 5, []string{"B1"}},
+		{"c", 0, []string{}},
+	} {
+		got := RecentStatuses(userId, tc.from, tc.limit)
+		ids := make([]string, len(got))
+		for i, s := range got {
+			ids[i] = s.ID
+		}
+		if len(ids) != len(tc.want) {
+			t.Errorf("from %q limit %d: got %v, want %v", tc.from, tc.limit, ids, tc.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.want[i] {
+				t.Errorf("from %q limit %d: got %v, want %v", tc.from, tc.limit, ids, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRecordStatusDefaultsTimestamp(t *testing.T) {
+	userId := newTestUser(t).ID
+	RecordStatus(userId, StatusUpdate{ID: "S1", From: "a", Type: "text"})
+	got := RecentStatuses(userId, "", 0)
+	if len(got) != 1 || got[0].Timestamp == "" {
+		t.Errorf("statuses = %+v, want one with a timestamp", got)
+	}
+}
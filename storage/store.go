@@ -65,12 +65,96 @@ func UserDataPath(userId string) string {
 
 // ── Models ──
 
+type ServerConfig struct {
+	BotName       string `json:"botName"`
+	Port          int    `json:"port"`
+	TunnelEnabled bool   `json:"tunnelEnabled"`
+	Require2FA    bool   `json:"require2FA"`
+
+	// AdminEmail is always granted the admin role, in addition to the first
+	// registered account.
+	AdminEmail         string `json:"adminEmail"`
+	RegistrationClosed bool   `json:"registrationClosed"`
+
+	// RejectUnregisteredNumbers makes sends to numbers that aren't on
+	// WhatsApp fail instead of being silently dropped.
+	RejectUnregisteredNumbers bool `json:"rejectUnregisteredNumbers"`
+	// DefaultCountryCode (e.g. "254") is prepended to phone numbers given
+	// in local format with a leading 0.
+	DefaultCountryCode string `json:"defaultCountryCode"`
+
+	RateLimits RateLimitConfig `json:"rateLimits"`
+
+	// OtpDelivery selects how password-reset codes are delivered:
+	// "stdout" (default, for development), "smtp" or "whatsapp".
+	OtpDelivery string     `json:"otpDelivery"`
+	SMTP        SMTPConfig `json:"smtp"`
+	// OtpWhatsAppSender is the user ID whose linked WhatsApp instance sends
+	// reset codes when OtpDelivery is "whatsapp".
+	OtpWhatsAppSender string `json:"otpWhatsAppSender"`
+}
+
+// RateLimitConfig throttles the unauthenticated /auth endpoints. Zero
+// values fall back to the defaults in DefaultRateLimits.
+type RateLimitConfig struct {
+	// IPRequestsPerMinute caps auth requests from one IP address.
+	IPRequestsPerMinute int `json:"ipRequestsPerMinute"`
+	// ResetRequestsPerHour caps password-reset codes sent to one email.
+	ResetRequestsPerHour int `json:"resetRequestsPerHour"`
+	// FreeFailures is how many failed logins per IP or email are allowed
+	// before each further attempt must wait BaseDelaySeconds, doubling per
+	// failure up to MaxDelaySeconds.
+	FreeFailures     int `json:"freeFailures"`
+	BaseDelaySeconds int `json:"baseDelaySeconds"`
+	MaxDelaySeconds  int `json:"maxDelaySeconds"`
+	// LockoutThreshold consecutive failed logins lock the account for
+	// LockoutMinutes.
+	LockoutThreshold int `json:"lockoutThreshold"`
+	LockoutMinutes   int `json:"lockoutMinutes"`
+}
+
+var DefaultRateLimits = RateLimitConfig{
+	IPRequestsPerMinute:  20,
+	ResetRequestsPerHour: 3,
+	FreeFailures:         3,
+	BaseDelaySeconds:     1,
+	MaxDelaySeconds:      300,
+	LockoutThreshold:     10,
+	LockoutMinutes:       15,
+}
+
+// Effective returns the config with unset fields filled from the defaults.
+func (r RateLimitConfig) Effective() RateLimitConfig {
+	fill := func(v *int, def int) {
+		if *v <= 0 {
+			*v = def
+		}
+	}
+	fill(&r.IPRequestsPerMinute, DefaultRateLimits.IPRequestsPerMinute)
+	fill(&r.ResetRequestsPerHour, DefaultRateLimits.ResetRequestsPerHour)
+	fill(&r.FreeFailures, DefaultRateLimits.FreeFailures)
+	fill(&r.BaseDelaySeconds, DefaultRateLimits.BaseDelaySeconds)
+	fill(&r.MaxDelaySeconds, DefaultRateLimits.MaxDelaySeconds)
+	fill(&r.LockoutThreshold, DefaultRateLimits.LockoutThreshold)
+	fill(&r.LockoutMinutes, DefaultRateLimits.LockoutMinutes)
+	return r
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	// TLS is "starttls" (default), "tls" for implicit TLS, or "none".
+	TLS          string `json:"tls"`
+	Subject      string `json:"subject"`
+	TextTemplate string `json:"textTemplate"`
+	HTMLTemplate string `json:"htmlTemplate"`
+}
+
 type GlobalConfig struct {
-	Config struct {
-		BotName       string `json:"botName"`
-		Port          int    `json:"port"`
-		TunnelEnabled bool   `json:"tunnelEnabled"`
-	} `json:"config"`
+	Config ServerConfig `json:"config"`
 }
 
 type UserStats struct {
@@ -86,6 +170,17 @@ type UserData struct {
 	Groups   []interface{} `json:"groups"`
 	Webhooks []interface{} `json:"webhooks"`
 	Stats    UserStats     `json:"stats"`
+	// IgnoredSenders are the numbers (or LIDs) whose incoming messages are
+	// dropped before being stored or forwarded. Unlike a WhatsApp block the
+	// sender isn't told.
+	IgnoredSenders []string `json:"ignoredSenders,omitempty"`
+	// Chats maps chat JIDs to their archive, pin, mute and read state.
+	Chats      map[string]ChatState `json:"chats,omitempty"`
+	Calls      []CallRecord         `json:"calls,omitempty"`
+	CallPolicy CallPolicy           `json:"callPolicy"`
+	// LinkedPhone is the number of the WhatsApp account linked to the
+	// instance, kept so it's known while the instance isn't loaded.
+	LinkedPhone string `json:"linkedPhone,omitempty"`
 }
 
 var DefaultUserData = UserData{
@@ -96,14 +191,11 @@ var DefaultUserData = UserData{
 }
 
 var DefaultGlobal = GlobalConfig{
-	Config: struct {
-		BotName       string `json:"botName"`
-		Port          int    `json:"port"`
-		TunnelEnabled bool   `json:"tunnelEnabled"`
-	}{
+	Config: ServerConfig{
 		BotName:       "WA Bot Server",
 		Port:          3000,
 		TunnelEnabled: false,
+		RateLimits:    DefaultRateLimits,
 	},
 }
 
@@ -138,6 +230,18 @@ func GetGlobalConfig() GlobalConfig {
 	return conf
 }
 
+// UpdateGlobalConfig applies fn to the stored config and persists the result.
+func UpdateGlobalConfig(fn func(conf *ServerConfig)) GlobalConfig {
+	conf := GetGlobalConfig()
+
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+
+	fn(&conf.Config)
+	saveGlobalConfigRaw(conf)
+	return conf
+}
+
 func saveGlobalConfigRaw(conf GlobalConfig) {
 	data, _ := json.MarshalIndent(conf, "", "  ")
 	os.WriteFile(globalConfigPath, data, 0644)
@@ -182,8 +286,31 @@ func LoadUser(userId string) UserData {
 
 		return DefaultUserData
 	}
+	return readUserFile(safeId)
+}
+
+// UpdateUser runs fn against the user's data while holding their lock, so
+// concurrent read-modify-write cycles (message events, calls, chat state)
+// can't clobber each other. The file is only written back when fn reports a
+// change.
+func UpdateUser(userId string, fn func(data *UserData) bool) {
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return
+	}
+
+	lock := getUserLock(safeId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data := readUserFile(safeId)
+	if fn(&data) {
+		writeUserFile(safeId, data)
+	}
+}
 
-	data, err := os.ReadFile(p)
+func readUserFile(safeId string) UserData {
+	data, err := os.ReadFile(UserDataPath(safeId))
 	if err != nil {
 		return DefaultUserData
 	}
@@ -207,16 +334,7 @@ func LoadUser(userId string) UserData {
 	return ud
 }
 
-func SaveUser(userId string, data UserData) {
-	safeId, err := sanitizeUserId(userId)
-	if err != nil {
-		return
-	}
-
-	lock := getUserLock(safeId)
-	lock.Lock()
-	defer lock.Unlock()
-
+func writeUserFile(safeId string, data UserData) {
 	p := UserDataPath(safeId)
 	os.MkdirAll(filepath.Dir(p), 0755)
 
@@ -225,56 +343,114 @@ func SaveUser(userId string, data UserData) {
 }
 
 func PushToUserMessage(userId string, item interface{}) {
-	data := LoadUser(userId)
-	data.Messages = append(data.Messages, item)
+	var dropped []interface{}
+	UpdateUser(userId, func(data *UserData) bool {
+		data.Messages = append(data.Messages, item)
+		if len(data.Messages) > 500 {
+			dropped = data.Messages[:len(data.Messages)-500]
+			data.Messages = data.Messages[len(data.Messages)-500:]
+		}
+		return true
+	})
+	removeMessageContent(userId, dropped)
+}
 
-	if len(data.Messages) > 500 {
-		data.Messages = data.Messages[len(data.Messages)-500:]
+// GetMessage returns the logged message with the given ID.
+func GetMessage(userId string, id string) (map[string]interface{}, bool) {
+	for _, m := range LoadUser(userId).Messages {
+		if msg, ok := m.(map[string]interface{}); ok && msg["id"] == id {
+			return msg, true
+		}
 	}
+	return nil, false
+}
 
-	SaveUser(userId, data)
+// HasMessage reports whether a message with the given ID is in the log.
+func HasMessage(userId string, id string) bool {
+	_, ok := GetMessage(userId, id)
+	return ok
 }
 
 func IncrementStatUser(userId string, statKey string) {
-	data := LoadUser(userId)
-	switch statKey {
-	case "messagesSent":
-		data.Stats.MessagesSent++
-	case "messagesReceived":
-		data.Stats.MessagesReceived++
-	case "groupsJoined":
-		data.Stats.GroupsJoined++
-	case "groupsLeft":
-		data.Stats.GroupsLeft++
+	UpdateUser(userId, func(data *UserData) bool {
+		switch statKey {
+		case "messagesSent":
+			data.Stats.MessagesSent++
+		case "messagesReceived":
+			data.Stats.MessagesReceived++
+		case "groupsJoined":
+			data.Stats.GroupsJoined++
+		case "groupsLeft":
+			data.Stats.GroupsLeft++
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+// GetLinkedPhone returns the number of the WhatsApp account linked to the
+// instance, or "" if none is.
+func GetLinkedPhone(userId string) string {
+	return LoadUser(userId).LinkedPhone
+}
+
+// SetLinkedPhone records the number of the linked WhatsApp account; ""
+// clears it after a logout.
+func SetLinkedPhone(userId string, phone string) {
+	UpdateUser(userId, func(data *UserData) bool {
+		if data.LinkedPhone == phone {
+			return false
+		}
+		data.LinkedPhone = phone
+		return true
+	})
+}
+
+// DeleteUserData removes the user's whole data directory, including their
+// WhatsApp session database.
+func DeleteUserData(userId string) error {
+	safeId, err := sanitizeUserId(userId)
+	if err != nil {
+		return err
 	}
-	SaveUser(userId, data)
+
+	lock := getUserLock(safeId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return os.RemoveAll(filepath.Join(usersDir, safeId))
 }
 
 func ClearUserBotData(userId string) {
-	data := LoadUser(userId)
-	data.Messages = make([]interface{}, 0)
-	data.Webhooks = make([]interface{}, 0)
-	data.Stats = UserStats{}
-	SaveUser(userId, data)
+	UpdateUser(userId, func(data *UserData) bool {
+		data.Messages = make([]interface{}, 0)
+		data.Webhooks = make([]interface{}, 0)
+		data.Stats = UserStats{}
+		return true
+	})
+	clearMessageContent(userId)
 }
 
 func RegisterWebhook(userId string, hook map[string]interface{}) {
-	data := LoadUser(userId)
-	data.Webhooks = append(data.Webhooks, hook)
-	SaveUser(userId, data)
+	UpdateUser(userId, func(data *UserData) bool {
+		data.Webhooks = append(data.Webhooks, hook)
+		return true
+	})
 }
 
 func UnregisterWebhook(userId string, hookId string) {
-	data := LoadUser(userId)
-	var newHooks []interface{}
-	for _, h := range data.Webhooks {
-		hw := h.(map[string]interface{})
-		if fmt.Sprintf("%v", hw["id"]) != hookId {
-			newHooks = append(newHooks, h)
+	UpdateUser(userId, func(data *UserData) bool {
+		var newHooks []interface{}
+		for _, h := range data.Webhooks {
+			hw := h.(map[string]interface{})
+			if fmt.Sprintf("%v", hw["id"]) != hookId {
+				newHooks = append(newHooks, h)
+			}
 		}
-	}
-	data.Webhooks = newHooks
-	SaveUser(userId, data)
+		data.Webhooks = newHooks
+		return true
+	})
 }
 
 func GetWebhooks(userId string) []interface{} {
@@ -282,3 +458,57 @@ func GetWebhooks(userId string) []interface{} {
 	return data.Webhooks
 }
 
+// ── Ignore list ──
+
+func GetIgnoredSenders(userId string) []string {
+	ignored := LoadUser(userId).IgnoredSenders
+	if ignored == nil {
+		ignored = make([]string, 0)
+	}
+	return ignored
+}
+
+// IgnoreSender adds sender to the ignore list. It returns false if it was
+// already listed.
+func IgnoreSender(userId string, sender string) bool {
+	added := false
+	UpdateUser(userId, func(data *UserData) bool {
+		for _, s := range data.IgnoredSenders {
+			if s == sender {
+				return false
+			}
+		}
+		data.IgnoredSenders = append(data.IgnoredSenders, sender)
+		added = true
+		return true
+	})
+	return added
+}
+
+// UnignoreSender removes sender from the ignore list. It returns false if
+// it wasn't listed.
+func UnignoreSender(userId string, sender string) bool {
+	removed := false
+	UpdateUser(userId, func(data *UserData) bool {
+		for i, s := range data.IgnoredSenders {
+			if s == sender {
+				data.IgnoredSenders = append(data.IgnoredSenders[:i], data.IgnoredSenders[i+1:]...)
+				removed = true
+				return true
+			}
+		}
+		return false
+	})
+	return removed
+}
+
+func IsIgnoredSender(userId string, senders ...string) bool {
+	for _, s := range LoadUser(userId).IgnoredSenders {
+		for _, sender := range senders {
+			if sender != "" && s == sender {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestUpdateUserConcurrentWrites(t *testing.T) {
+	userId := newTestUser(t).ID
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			PushToUserMessage(userId, map[string]interface{}{"id": fmt.Sprintf("MSG%d", i)})
+		}(i)
+		go func() {
+			defer wg.Done()
+			IncrementStatUser(userId, "messagesReceived")
+		}()
+		go func(i int) {
+			defer wg.Done()
+			RecordCall(userId, CallRecord{ID: fmt.Sprintf("CALL%d", i), Status: "ringing"})
+		}(i)
+	}
+	wg.Wait()
+
+	data := LoadUser(userId)
+	if len(data.Messages) != 20 || len(data.Calls) != 20 || data.Stats.MessagesReceived != 20 {
+		t.Errorf("got %d messages, %d calls and %d received; want 20 of each",
+			len(data.Messages), len(data.Calls), data.Stats.MessagesReceived)
+	}
+}
+
+func TestUpdateUserSkipsUnchanged(t *testing.T) {
+	userId := newTestUser(t).ID
+	IgnoreSender(userId, "254712345678")
+	if IgnoreSender(userId, "254712345678") {
+		t.Error("the same sender was ignored twice")
+	}
+	if !UnignoreSender(userId, "254712345678") || UnignoreSender(userId, "254712345678") {
+		t.Error("UnignoreSender didn't report the removal once")
+	}
+	if got := GetIgnoredSenders(userId); len(got) != 0 {
+		t.Errorf("ignored senders = %v", got)
+	}
+}
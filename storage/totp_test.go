@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stepCode returns the TOTP code for secret at the given time step.
+func stepCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := base32NoPad.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, step)
+}
+
+func currentStep() int64 {
+	return time.Now().Unix() / totpPeriod
+}
+
+// nextCode returns a code for the step after the last one the user
+// consumed, which is still within the allowed drift.
+func nextCode(t *testing.T, userId string, secret string) string {
+	t.Helper()
+	return stepCode(t, secret, FindUserByID(userId).TotpLastStep+1)
+}
+
+// enableTestTotp turns 2FA on for user and returns the secret and recovery
+// codes. The current step is consumed by enabling.
+func enableTestTotp(t *testing.T, userId string) (string, []string) {
+	t.Helper()
+	secret, _, err := BeginTotpSetup(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := EnableTotp(userId, stepCode(t, secret, currentStep()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+func TestTotpCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits
+	key := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	} {
+		if got := totpCode(key, tc.unix/30); got != tc.want {
+			t.Errorf("totpCode at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	secret := base32NoPad.EncodeToString([]byte("12345678901234567890"))
+	// Steps are compared to the clock, so skip a test run that straddles a
+	// step boundary
+	now := currentStep()
+	defer func() {
+		if currentStep() != now {
+			t.Skip("crossed a TOTP step boundary")
+		}
+	}()
+
+	for _, offset := range []int64{-totpSkew, 0, totpSkew} {
+		if step, ok := verifyTotp(secret, stepCode(t, secret, now+offset), 0); !ok || step != now+offset {
+			t.Errorf("code %d steps away: step %d ok %v, want step %d", offset, step, ok, now+offset)
+		}
+	}
+	if _, ok := verifyTotp(secret, stepCode(t, secret, now+totpSkew+1), 0); ok {
+		t.Error("a code outside the allowed drift was accepted")
+	}
+	if _, ok := verifyTotp(secret, stepCode(t, secret, now), now); ok {
+		t.Error("a code for an already used step was accepted")
+	}
+	code := stepCode(t, secret, now)
+	if _, ok := verifyTotp(strings.ToLower(secret), code[:3]+" "+code[3:], 0); !ok {
+		t.Error("a spaced code for a lowercase secret was rejected")
+	}
+}
+
+func TestTotpSetup(t *testing.T) {
+	user := newTestUser(t)
+	if _, err := EnableTotp(user.ID, "123456"); err == nil {
+		t.Error("enabling before setup succeeded")
+	}
+
+	secret, uri, err := BeginTotpSetup(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "otpauth" || u.Host != "totp" || u.Query().Get("secret") != secret {
+		t.Errorf("unexpected setup URI %q", uri)
+	}
+	if _, err := EnableTotp(user.ID, "000000"); err == nil {
+		t.Error("enabling with a wrong code succeeded")
+	}
+
+	codes, err := EnableTotp(user.ID, stepCode(t, secret, currentStep()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodes {
+		t.Errorf("got %d recovery codes, want %d", len(codes), recoveryCodes)
+	}
+	stored := FindUserByID(user.ID)
+	if !stored.TotpEnabled || len(stored.RecoveryCodeHashes) != recoveryCodes {
+		t.Errorf("2FA state not stored: enabled %v, %d recovery hashes", stored.TotpEnabled, len(stored.RecoveryCodeHashes))
+	}
+	for _, h := range stored.RecoveryCodeHashes {
+		for _, c := range codes {
+			if h == c {
+				t.Fatal("recovery codes are stored in plain text")
+			}
+		}
+	}
+	if _, _, err := BeginTotpSetup(user.ID); err == nil {
+		t.Error("setup restarted while 2FA is enabled")
+	}
+}
+
+func TestLoginChallenge(t *testing.T) {
+	user := newTestUser(t)
+	secret, _ := enableTestTotp(t, user.ID)
+
+	challenge := CreateLoginChallenge(user.ID)
+	if owner := LoginChallengeOwner(challenge); owner != user.ID {
+		t.Errorf("LoginChallengeOwner = %q, want %q", owner, user.ID)
+	}
+	// The code used to enable 2FA can't be replayed
+	used := FindUserByID(user.ID).TotpLastStep
+	if _, err := CompleteLoginChallenge(challenge, stepCode(t, secret, used)); err == nil {
+		t.Fatal("a replayed code completed the login")
+	}
+	next := stepCode(t, secret, used+1)
+	loggedIn, err := CompleteLoginChallenge(challenge, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Errorf("logged in as %s, want %s", loggedIn.ID, user.ID)
+	}
+	if _, err := CompleteLoginChallenge(challenge, next); err == nil {
+		t.Error("a completed challenge was accepted again")
+	}
+	if owner := LoginChallengeOwner(challenge); owner != "" {
+		t.Error("a completed challenge still has an owner")
+	}
+}
+
+func TestLoginChallengeAttempts(t *testing.T) {
+	user := newTestUser(t)
+	secret, _ := enableTestTotp(t, user.ID)
+
+	challenge := CreateLoginChallenge(user.ID)
+	for i := 0; i < maxOtpAttempts; i++ {
+		if _, err := CompleteLoginChallenge(challenge, "000000"); err == nil {
+			t.Fatal("a wrong code completed the login")
+		}
+	}
+	if _, err := CompleteLoginChallenge(challenge, nextCode(t, user.ID, secret)); err == nil {
+		t.Error("a correct code was accepted after too many failures")
+	}
+
+	challenge = CreateLoginChallenge(user.ID)
+	setUser(t, user.ID, func(u *AuthUser) {
+		expired := time.Now().Add(-time.Second).UnixMilli()
+		u.LoginChallengeExp = &expired
+	})
+	if _, err := CompleteLoginChallenge(challenge, nextCode(t, user.ID, secret)); err == nil {
+		t.Error("an expired challenge was accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	user := newTestUser(t)
+	secret, codes := enableTestTotp(t, user.ID)
+
+	challenge := CreateLoginChallenge(user.ID)
+	if _, err := CompleteLoginChallenge(challenge, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("recovery code rejected: %v", err)
+	}
+	if left := len(FindUserByID(user.ID).RecoveryCodeHashes); left != recoveryCodes-1 {
+		t.Errorf("%d recovery codes left, want %d", left, recoveryCodes-1)
+	}
+	challenge = CreateLoginChallenge(user.ID)
+	if _, err := CompleteLoginChallenge(challenge, codes[0]); err == nil {
+		t.Error("a recovery code was accepted twice")
+	}
+
+	fresh, err := RegenerateRecoveryCodes(user.ID, nextCode(t, user.ID, secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != recoveryCodes {
+		t.Errorf("got %d new recovery codes, want %d", len(fresh), recoveryCodes)
+	}
+	challenge = CreateLoginChallenge(user.ID)
+	if _, err := CompleteLoginChallenge(challenge, codes[1]); err == nil {
+		t.Error("an old recovery code still works after regenerating")
+	}
+}
+
+func TestDisableTotp(t *testing.T) {
+	user := newTestUser(t)
+	_, codes := enableTestTotp(t, user.ID)
+
+	if err := DisableTotp(user.ID, "wrong-password", codes[0]); err == nil {
+		t.Error("disabled with a wrong password")
+	}
+	if err := DisableTotp(user.ID, "password123", "000000"); err == nil {
+		t.Error("disabled with a wrong code")
+	}
+
+	setUser(t, user.ID, func(u *AuthUser) { u.TotpRequired = true })
+	if err := DisableTotp(user.ID, "password123", codes[0]); err == nil {
+		t.Error("disabled 2FA that is enforced for the account")
+	}
+	setUser(t, user.ID, func(u *AuthUser) { u.TotpRequired = false })
+
+	if err := DisableTotp(user.ID, "password123", codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	stored := FindUserByID(user.ID)
+	if stored.TotpEnabled || stored.TotpSecret != nil || len(stored.RecoveryCodeHashes) != 0 {
+		t.Error("2FA state wasn't cleared")
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// UserSummary is the admin-facing view of an account, without secrets.
+type UserSummary struct {
+	ID             string `json:"id"`
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+	Disabled       bool   `json:"disabled"`
+	CreatedAt      string `json:"createdAt"`
+	TotpEnabled    bool   `json:"totpEnabled"`
+	TotpRequired   bool   `json:"totpRequired"`
+	ActiveSessions int    `json:"activeSessions"`
+	LockedUntil    string `json:"lockedUntil,omitempty"`
+}
+
+func isAdminEmail(email string) bool {
+	adminEmail := strings.TrimSpace(strings.ToLower(GetGlobalConfig().Config.AdminEmail))
+	return adminEmail != "" && email == adminEmail
+}
+
+// IsAdmin reports whether the user holds the admin role, either stored on
+// the account or granted through the configured admin email.
+func IsAdmin(user *AuthUser) bool {
+	return user.Role == RoleAdmin || isAdminEmail(user.Email)
+}
+
+// EnsureAdmin promotes the first registered account when no admin exists,
+// so installs created before roles existed still have one.
+func EnsureAdmin() {
+	updateAuth(func(auth *AuthData) bool {
+		if len(auth.Users) == 0 || GetGlobalConfig().Config.AdminEmail != "" {
+			return false
+		}
+		for _, u := range auth.Users {
+			if u.Role == RoleAdmin {
+				return false
+			}
+		}
+		auth.Users[0].Role = RoleAdmin
+		return true
+	})
+}
+
+func FindUserByID(userId string) *AuthUser {
+	auth := loadAuth()
+	for i := range auth.Users {
+		if auth.Users[i].ID == userId {
+			return &auth.Users[i]
+		}
+	}
+	return nil
+}
+
+// summarize must not take the auth lock itself, since modifyUser calls it
+// while holding the write lock.
+func summarize(user AuthUser) UserSummary {
+	now := time.Now().UnixMilli()
+	active := 0
+	for _, s := range user.Sessions {
+		if s.ExpiresAt > now {
+			active++
+		}
+	}
+
+	role := user.Role
+	if IsAdmin(&user) {
+		role = RoleAdmin
+	} else if role == "" {
+		role = RoleUser
+	}
+	summary := UserSummary{
+		ID:             user.ID,
+		Email:          user.Email,
+		Role:           role,
+		Disabled:       user.Disabled,
+		CreatedAt:      user.CreatedAt,
+		TotpEnabled:    user.TotpEnabled,
+		TotpRequired:   user.TotpRequired,
+		ActiveSessions: active,
+	}
+	if user.LockedUntil > now {
+		summary.LockedUntil = formatMillis(user.LockedUntil)
+	}
+	return summary
+}
+
+func ListUsers() []UserSummary {
+	auth := loadAuth()
+	result := make([]UserSummary, 0, len(auth.Users))
+	for _, u := range auth.Users {
+		result = append(result, summarize(u))
+	}
+	return result
+}
+
+// modifyUser applies fn to the user with the given ID and persists it.
+func modifyUser(userId string, fn func(user *AuthUser) error) (*UserSummary, error) {
+	var result *UserSummary
+	var modifyErr error
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID != userId {
+				continue
+			}
+			if modifyErr = fn(&auth.Users[i]); modifyErr != nil {
+				return false
+			}
+			summary := summarize(auth.Users[i])
+			result = &summary
+			return true
+		}
+		modifyErr = errors.New("User not found")
+		return false
+	})
+	return result, modifyErr
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling also ends
+// all of its sessions.
+func SetUserDisabled(userId string, disabled bool) (*UserSummary, error) {
+	return modifyUser(userId, func(user *AuthUser) error {
+		user.Disabled = disabled
+		if disabled {
+			user.Sessions = nil
+		} else {
+			user.FailedLogins = 0
+			user.LockedUntil = 0
+		}
+		return nil
+	})
+}
+
+// UnlockUser lifts a lockout from failed logins before it expires.
+func UnlockUser(userId string) (*UserSummary, error) {
+	return modifyUser(userId, func(user *AuthUser) error {
+		user.FailedLogins = 0
+		user.LockedUntil = 0
+		return nil
+	})
+}
+
+func SetUserRole(userId string, role string) (*UserSummary, error) {
+	if role != RoleAdmin && role != RoleUser {
+		return nil, errors.New("Role must be \"admin\" or \"user\"")
+	}
+	return modifyUser(userId, func(user *AuthUser) error {
+		user.Role = role
+		return nil
+	})
+}
+
+// SetTotpRequired enforces (or stops enforcing) 2FA for a single account.
+func SetTotpRequired(userId string, required bool) (*UserSummary, error) {
+	return modifyUser(userId, func(user *AuthUser) error {
+		user.TotpRequired = required
+		return nil
+	})
+}
+
+// AdminSetPassword replaces the user's password and signs out all of their
+// sessions.
+func AdminSetPassword(userId string, newPassword string) (*UserSummary, error) {
+	if len(newPassword) < 6 {
+		return nil, errors.New("Password must be at least 6 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcryptRounds)
+	if err != nil {
+		return nil, err
+	}
+	return modifyUser(userId, func(user *AuthUser) error {
+		user.PasswordHash = string(hash)
+		user.Sessions = nil
+		user.ResetOtpHash = nil
+		user.ResetOtpExpires = nil
+		user.ResetOtpAttempts = nil
+		return nil
+	})
+}
+
+// DeleteUser removes the account and its data directory. The caller is
+// responsible for shutting down the user's WhatsApp instance first.
+func DeleteUser(userId string) error {
+	found := false
+	updateAuth(func(auth *AuthData) bool {
+		for i := range auth.Users {
+			if auth.Users[i].ID == userId {
+				auth.Users = append(auth.Users[:i], auth.Users[i+1:]...)
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		return errors.New("User not found")
+	}
+	return DeleteUserData(userId)
+}
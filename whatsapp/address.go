@@ -0,0 +1,148 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ErrInvalidAddress is returned when a phone number, JID or group ID can't
+// be parsed. API handlers report it as a client error.
+var ErrInvalidAddress = errors.New("invalid address")
+
+var (
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "\u00a0", "")
+	digitsRegex     = regexp.MustCompile(`^[0-9]+$`)
+	groupIdRegex    = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+)
+
+func invalidAddress(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAddress, fmt.Sprintf(format, args...))
+}
+
+// NormalizePhone turns a phone number in E.164 ("+254712345678"),
+// international ("00254…") or local ("0712345678") format into the digits
+// WhatsApp uses, country code included. Local numbers get the configured
+// default country code. Spaces, dashes, dots and parentheses are ignored.
+func NormalizePhone(input string) (string, error) {
+	number := phoneSeparators.Replace(strings.TrimSpace(input))
+	if number == "" {
+		return "", invalidAddress("phone number is required")
+	}
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case strings.HasPrefix(number, "0"):
+		cc := strings.TrimPrefix(storage.GetGlobalConfig().Config.DefaultCountryCode, "+")
+		if cc == "" {
+			return "", invalidAddress("%q is a local number and no default country code is configured; use international format", input)
+		}
+		number = cc + number[1:]
+	}
+
+	if !digitsRegex.MatchString(number) {
+		return "", invalidAddress("%q is not a valid phone number", input)
+	}
+	// E.164 allows at most 15 digits; nothing real is shorter than 7
+	if len(number) < 7 || len(number) > 15 {
+		return "", invalidAddress("%q is not a valid phone number", input)
+	}
+	return number, nil
+}
+
+// ParseUserJID accepts a phone number in any format NormalizePhone does, or
+// a full user JID (…@s.whatsapp.net) or LID (…@lid).
+func ParseUserJID(input string) (types.JID, error) {
+	input = strings.TrimSpace(input)
+	if strings.Contains(input, "@") {
+		jid, err := types.ParseJID(input)
+		if err != nil {
+			return types.JID{}, invalidAddress("%q is not a valid JID", input)
+		}
+		if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+			return types.JID{}, invalidAddress("%q is not a user JID", input)
+		}
+		if !digitsRegex.MatchString(jid.User) {
+			return types.JID{}, invalidAddress("%q is not a valid JID", input)
+		}
+		return jid.ToNonAD(), nil
+	}
+
+	number, err := NormalizePhone(input)
+	if err != nil {
+		return types.JID{}, err
+	}
+	return types.NewJID(number, types.DefaultUserServer), nil
+}
+
+// ParseUserJIDs parses a list of recipients, failing on the first invalid
+// one.
+func ParseUserJIDs(inputs []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(inputs))
+	for _, in := range inputs {
+		jid, err := ParseUserJID(in)
+		if err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, nil
+}
+
+// ParseGroupJID accepts a group ID with or without the @g.us suffix.
+func ParseGroupJID(input string) (types.JID, error) {
+	input = strings.TrimSpace(input)
+	id := strings.TrimSuffix(input, "@"+types.GroupServer)
+	if id == "" {
+		return types.JID{}, invalidAddress("group ID is required")
+	}
+	if strings.Contains(id, "@") || !groupIdRegex.MatchString(id) {
+		return types.JID{}, invalidAddress("%q is not a valid group ID", input)
+	}
+	return types.NewJID(id, types.GroupServer), nil
+}
+
+// ParseChatJID accepts either a user (phone number, JID or LID) or a group
+// ID. Group IDs are recognised by their @g.us suffix, the creator-timestamp
+// form ("<number>-<timestamp>") older groups use, or the bare number newer
+// groups have (see bareGroupID).
+func ParseChatJID(input string) (types.JID, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasSuffix(input, "@"+types.GroupServer) || (!strings.Contains(input, "@") && strings.Contains(input, "-") && groupIdRegex.MatchString(input)) || bareGroupID(input) {
+		return ParseGroupJID(input)
+	}
+	return ParseUserJID(input)
+}
+
+// bareGroupID reports whether an all-digit input is a modern group ID
+// ("120363…", 18 digits) rather than a phone number. Anything longer than
+// E.164's 15 digits can't be a phone, and numbers starting with country
+// code 1 are always 11 digits, so a longer one starting 120363 can't either.
+func bareGroupID(input string) bool {
+	if !digitsRegex.MatchString(input) {
+		return false
+	}
+	return len(input) > 15 || (strings.HasPrefix(input, "120363") && len(input) > 11)
+}
+
+// ParseNewsletterJID accepts a channel ID with or without the @newsletter
+// suffix.
+func ParseNewsletterJID(input string) (types.JID, error) {
+	input = strings.TrimSpace(input)
+	id := strings.TrimSuffix(input, "@"+types.NewsletterServer)
+	if id == "" {
+		return types.JID{}, invalidAddress("channel ID is required")
+	}
+	if !digitsRegex.MatchString(id) {
+		return types.JID{}, invalidAddress("%q is not a valid channel ID", input)
+	}
+	return types.NewJID(id, types.NewsletterServer), nil
+}
@@ -0,0 +1,60 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestParseChatJID(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  types.JID
+	}{
+		// Phone numbers
+		{"+254712345678", types.NewJID("254712345678", types.DefaultUserServer)},
+		{"00254 712 345 678", types.NewJID("254712345678", types.DefaultUserServer)},
+		{"254712345678", types.NewJID("254712345678", types.DefaultUserServer)},
+		// A US number that happens to start like a modern group ID
+		{"12036312345", types.NewJID("12036312345", types.DefaultUserServer)},
+		// User JIDs and LIDs
+		{"254712345678@s.whatsapp.net", types.NewJID("254712345678", types.DefaultUserServer)},
+		{"254712345678:3@s.whatsapp.net", types.NewJID("254712345678", types.DefaultUserServer)},
+		{"123456789012345@lid", types.NewJID("123456789012345", types.HiddenUserServer)},
+		// Groups with the suffix
+		{"120363025246125486@g.us", types.NewJID("120363025246125486", types.GroupServer)},
+		{"254712345678-1600000000@g.us", types.NewJID("254712345678-1600000000", types.GroupServer)},
+		// Legacy creator-timestamp group IDs
+		{"254712345678-1600000000", types.NewJID("254712345678-1600000000", types.GroupServer)},
+		// Bare modern group IDs
+		{"120363025246125486", types.NewJID("120363025246125486", types.GroupServer)},
+		{" 120363025246125486 ", types.NewJID("120363025246125486", types.GroupServer)},
+		{"1203630252461254", types.NewJID("1203630252461254", types.GroupServer)},
+		{"9876543210987654321", types.NewJID("9876543210987654321", types.GroupServer)},
+	} {
+		got, err := ParseChatJID(tc.input)
+		if err != nil {
+			t.Errorf("ParseChatJID(%q): %v", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseChatJID(%q) = %s, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseChatJIDRejects(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"12345",
+		"hello",
+		"120363025246125486@newsletter",
+		"abc@g.us",
+		"@g.us",
+	} {
+		if jid, err := ParseChatJID(input); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("ParseChatJID(%q) = %s, %v; want ErrInvalidAddress", input, jid, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ── Calls ──
+//
+// The server can't answer calls, but it logs them, forwards them to
+// webhooks and, if the instance's call policy says so, rejects them and
+// tells the caller to send a message instead.
+
+// callerJID prefers the caller's phone number over their LID.
+func callerJID(meta types.BasicCallMeta) types.JID {
+	if meta.CallCreator.Server == types.HiddenUserServer && !meta.CallCreatorAlt.IsEmpty() {
+		return meta.CallCreatorAlt.ToNonAD()
+	}
+	return meta.CallCreator.ToNonAD()
+}
+
+func callEventData(call storage.CallRecord) map[string]interface{} {
+	return map[string]interface{}{
+		"callId":      call.ID,
+		"from":        call.From,
+		"contactName": call.ContactName,
+		"isVideo":     call.IsVideo,
+		"isGroup":     call.IsGroup,
+		"groupId":     call.GroupID,
+		"status":      call.Status,
+		"reason":      call.Reason,
+		"timestamp":   call.Timestamp,
+	}
+}
+
+// handleIncomingCall logs a ringing call, emits call.offer and applies the
+// instance's call policy, once per call ID.
+func handleIncomingCall(userId string, client *whatsmeow.Client, meta types.BasicCallMeta, isVideo bool) {
+	caller := callerJID(meta)
+	call := storage.CallRecord{
+		ID:          meta.CallID,
+		From:        caller.String(),
+		ContactName: resolveContactName(userId, client, caller),
+		IsVideo:     isVideo,
+		IsGroup:     !meta.GroupJID.IsEmpty(),
+		Status:      "ringing",
+		Timestamp:   meta.Timestamp.UTC().Format(time.RFC3339),
+	}
+	if call.IsGroup {
+		call.GroupID = meta.GroupJID.User
+	}
+	if !storage.RecordCall(userId, call) {
+		return // already handled from another event for the same call
+	}
+	emitEvent(userId, "call.offer", callEventData(call))
+
+	policy := storage.GetCallPolicy(userId)
+	if !policy.AutoReject {
+		return
+	}
+	if err := client.RejectCall(context.Background(), meta.From, meta.CallID); err != nil {
+		fmt.Printf("⚠️ [%.8s] Failed to reject call %s: %v\n", userId, meta.CallID, err)
+		return
+	}
+	call, _ = storage.UpdateCall(userId, meta.CallID, func(c *storage.CallRecord) {
+		c.Status = "rejected"
+		c.AutoReject = true
+		c.EndedAt = time.Now().UTC().Format(time.RFC3339)
+	})
+	data := callEventData(call)
+	data["autoRejected"] = true
+	emitEvent(userId, "call.rejected", data)
+
+	if policy.RejectMessage != "" {
+		go func() {
+			if _, err := SendMessage(userId, caller.String(), policy.RejectMessage, SendOptions{}); err != nil {
+				fmt.Printf("⚠️ [%.8s] Failed to send call reply to %s: %v\n", userId, caller.User, err)
+			}
+		}()
+	}
+}
+
+// finishCall updates a logged call when it's answered, declined or ends.
+func finishCall(userId string, callId string, status string, reason string) {
+	if call, ok := storage.FinishCall(userId, callId, status, reason); ok {
+		emitEvent(userId, "call."+call.Status, callEventData(call))
+	}
+}
+
+func handleCall(userId string, client *whatsmeow.Client, evt interface{}) {
+	switch v := evt.(type) {
+	case *events.CallOffer:
+		isVideo := v.Data != nil && len(v.Data.GetChildrenByTag("video")) > 0
+		handleIncomingCall(userId, client, v.BasicCallMeta, isVideo)
+	case *events.CallOfferNotice:
+		handleIncomingCall(userId, client, v.BasicCallMeta, v.Media == "video")
+	case *events.CallAccept:
+		finishCall(userId, v.CallID, "accepted", "")
+	case *events.CallReject:
+		finishCall(userId, v.CallID, "rejected", "")
+	case *events.CallTerminate:
+		finishCall(userId, v.CallID, "ended", v.Reason)
+	}
+}
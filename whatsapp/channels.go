@@ -0,0 +1,229 @@
+package whatsapp
+
+import (
+	"context"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ── Channels ──
+//
+// WhatsApp Channels are one-way broadcast feeds (newsletters in the
+// protocol). Posts are sent unencrypted, so channel media uses a separate
+// upload path.
+
+func channelView(meta *types.NewsletterMetadata) map[string]interface{} {
+	thread := meta.ThreadMeta
+	view := map[string]interface{}{
+		"id":          meta.ID.User,
+		"jid":         meta.ID.String(),
+		"name":        thread.Name.Text,
+		"description": thread.Description.Text,
+		"subscribers": thread.SubscriberCount,
+		"verified":    thread.VerificationState == types.NewsletterVerificationStateVerified,
+		"state":       meta.State.Type,
+		"inviteLink":  nil,
+		"pictureUrl":  nil,
+		"role":        nil,
+		"muted":       false,
+		"createdAt":   thread.CreationTime.Time.UTC(),
+	}
+	if thread.InviteCode != "" {
+		view["inviteLink"] = "https://whatsapp.com/channel/" + thread.InviteCode
+	}
+	if thread.Picture != nil && thread.Picture.URL != "" {
+		view["pictureUrl"] = thread.Picture.URL
+	}
+	if meta.ViewerMeta != nil {
+		view["role"] = meta.ViewerMeta.Role
+		view["muted"] = meta.ViewerMeta.Mute == types.NewsletterMuteOn
+	}
+	return view
+}
+
+// GetChannels lists the channels the account follows or owns.
+func GetChannels(userId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := client.GetSubscribedNewsletters(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(channels))
+	for _, meta := range channels {
+		result = append(result, channelView(meta))
+	}
+	return result, nil
+}
+
+func GetChannel(userId string, channelId string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseNewsletterJID(channelId)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := client.GetNewsletterInfo(context.Background(), jid)
+	if err != nil {
+		return nil, err
+	}
+	return channelView(meta), nil
+}
+
+// CreateChannel creates a channel owned by the account. The picture is
+// optional and is cropped to a square like group photos.
+func CreateChannel(userId string, name string, description string, picture []byte) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	params := whatsmeow.CreateNewsletterParams{Name: name, Description: description}
+	if len(picture) > 0 {
+		jpeg, err := groupPhotoJPEG(picture)
+		if err != nil {
+			return nil, err
+		}
+		params.Picture = jpeg
+	}
+	meta, err := client.CreateNewsletter(context.Background(), params)
+	if err != nil {
+		return nil, err
+	}
+	view := channelView(meta)
+	emitEvent(userId, "channel.created", view)
+	return view, nil
+}
+
+// PostToChannel publishes a text, image or video update. Only channel
+// owners and admins can post.
+func PostToChannel(userId string, channelId string, text string, media []byte, mimetype string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseNewsletterJID(channelId)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &waProto.Message{Conversation: &text}
+	extra := whatsmeow.SendRequestExtra{}
+	kind := "text"
+	if len(media) > 0 {
+		up, err := uploadMedia(client, media, mimetype, text, true)
+		if err != nil {
+			return nil, err
+		}
+		msg = up.Message
+		extra.MediaHandle = up.Handle
+		kind = up.Kind
+	}
+
+	resp, err := client.SendMessage(context.Background(), jid, msg, extra)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"success":   true,
+		"id":        resp.ID,
+		"serverId":  resp.ServerID,
+		"channel":   jid.String(),
+		"type":      kind,
+		"timestamp": resp.Timestamp.UTC(),
+	}, nil
+}
+
+// GetChannelPosts returns recent posts with view and reaction counts,
+// newest first. before pages back from a post's server ID.
+func GetChannelPosts(userId string, channelId string, count int, before int) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseNewsletterJID(channelId)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := client.GetNewsletterMessages(context.Background(), jid, &whatsmeow.GetNewsletterMessagesParams{
+		Count:  count,
+		Before: types.MessageServerID(before),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, 0, len(posts))
+	for i := len(posts) - 1; i >= 0; i-- {
+		p := posts[i]
+		reactions := p.ReactionCounts
+		if reactions == nil {
+			reactions = make(map[string]int)
+		}
+		post := map[string]interface{}{
+			"serverId":  p.MessageServerID,
+			"id":        p.MessageID,
+			"type":      p.Type,
+			"timestamp": p.Timestamp.UTC(),
+			"views":     p.ViewsCount,
+			"reactions": reactions,
+		}
+		if m := p.Message; m != nil {
+			switch {
+			case m.GetImageMessage() != nil:
+				post["type"] = "image"
+				post["text"] = m.GetImageMessage().GetCaption()
+			case m.GetVideoMessage() != nil:
+				post["type"] = "video"
+				post["text"] = m.GetVideoMessage().GetCaption()
+			case m.GetExtendedTextMessage() != nil:
+				post["text"] = m.GetExtendedTextMessage().GetText()
+			default:
+				post["text"] = m.GetConversation()
+			}
+		}
+		result = append(result, post)
+	}
+	return result, nil
+}
+
+// channelJID resolves a channel ID or a whatsapp.com/channel/… invite link.
+func channelJID(client *whatsmeow.Client, input string) (types.JID, error) {
+	const invitePrefix = "whatsapp.com/channel/"
+	if i := strings.Index(input, invitePrefix); i >= 0 {
+		meta, err := client.GetNewsletterInfoWithInvite(context.Background(), input[i+len(invitePrefix):])
+		if err != nil {
+			return types.JID{}, err
+		}
+		return meta.ID, nil
+	}
+	return ParseNewsletterJID(input)
+}
+
+// SetChannelFollowed follows or unfollows a channel, given its ID or
+// invite link.
+func SetChannelFollowed(userId string, channel string, follow bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := channelJID(client, channel)
+	if err != nil {
+		return nil, err
+	}
+	if follow {
+		err = client.FollowNewsletter(context.Background(), jid)
+	} else {
+		err = client.UnfollowNewsletter(context.Background(), jid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "channel": jid.String(), "following": follow}, nil
+}
@@ -0,0 +1,338 @@
+package whatsapp
+
+import (
+	"context"
+	"time"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// ── Chat state ──
+//
+// Archive, pin, mute, read and delete are app state patches: WhatsApp syncs
+// them to the phone and other linked devices. The resulting state is also
+// kept per instance so the chat list can show it, and patches made on other
+// devices are applied to it as they arrive.
+
+// lastMessage returns the timestamp and key of the newest logged message in
+// chat. WhatsApp uses them to scope archive, read and delete actions; both
+// are optional and zero when nothing is logged.
+func lastMessage(userId string, chat types.JID) (time.Time, *waCommon.MessageKey) {
+	msgs := storage.ChatMessages(userId, chat.String())
+	if len(msgs) == 0 {
+		return time.Time{}, nil
+	}
+	msg := msgs[len(msgs)-1]
+	id, _ := msg["id"].(string)
+	ts, _ := msg["timestamp"].(string)
+	t, err := time.Parse(time.RFC3339, ts)
+	if id == "" || err != nil {
+		return time.Time{}, nil
+	}
+
+	key := &waCommon.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(msg["type"] == "sent"),
+		ID:        proto.String(id),
+	}
+	if chat.Server == types.GroupServer && msg["type"] != "sent" {
+		from, _ := msg["from"].(string)
+		key.Participant = proto.String(from)
+	}
+	return t, key
+}
+
+// MarkChatRead sends read receipts (blue ticks) for incoming messages in a
+// chat. With no message IDs it marks everything received since the chat was
+// last read.
+func MarkChatRead(userId string, chat string, messageIds []string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	state := storage.GetChatState(userId, jid.String())
+	wanted := make(map[string]bool, len(messageIds))
+	for _, id := range messageIds {
+		wanted[id] = true
+	}
+
+	// Receipts are sent per sender; in one-to-one chats the sender is empty
+	bySender := make(map[types.JID][]types.MessageID)
+	readAt := state.ReadAt
+	for _, msg := range storage.ChatMessages(userId, jid.String()) {
+		if msg["type"] != "received" {
+			continue
+		}
+		id, _ := msg["id"].(string)
+		ts, _ := msg["timestamp"].(string)
+		if len(wanted) > 0 {
+			if !wanted[id] {
+				continue
+			}
+			delete(wanted, id)
+		} else if state.ReadAt != "" && ts <= state.ReadAt {
+			continue
+		}
+		var sender types.JID
+		if jid.Server == types.GroupServer {
+			from, _ := msg["from"].(string)
+			if sender, err = types.ParseJID(from); err != nil {
+				continue
+			}
+		}
+		bySender[sender] = append(bySender[sender], id)
+		if ts > readAt {
+			readAt = ts
+		}
+	}
+	// IDs that aren't in the log can still be marked in one-to-one chats
+	if jid.Server != types.GroupServer {
+		for id := range wanted {
+			bySender[types.EmptyJID] = append(bySender[types.EmptyJID], id)
+		}
+	}
+
+	marked := 0
+	for sender, ids := range bySender {
+		if err := client.MarkRead(context.Background(), ids, time.Now(), jid, sender); err != nil {
+			return nil, err
+		}
+		marked += len(ids)
+	}
+
+	if state.MarkedUnread {
+		ts, key := lastMessage(userId, jid)
+		if err := client.SendAppState(context.Background(), appstate.BuildMarkChatAsRead(jid, true, ts, key)); err != nil {
+			return nil, err
+		}
+	}
+	if len(messageIds) == 0 {
+		readAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) {
+		s.ReadAt = readAt
+		s.MarkedUnread = false
+	})
+	return map[string]interface{}{"success": true, "chat": jid.String(), "marked": marked}, nil
+}
+
+// MarkChatUnread flags a chat as unread on all devices, like "Mark as
+// unread" in the app. It sends no receipts.
+func MarkChatUnread(userId string, chat string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	ts, key := lastMessage(userId, jid)
+	if err := client.SendAppState(context.Background(), appstate.BuildMarkChatAsRead(jid, false, ts, key)); err != nil {
+		return nil, err
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) { s.MarkedUnread = true })
+	return map[string]interface{}{"success": true, "chat": jid.String(), "markedUnread": true}, nil
+}
+
+// SetChatArchived archives or unarchives a chat. Archiving also unpins it.
+func SetChatArchived(userId string, chat string, archive bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	ts, key := lastMessage(userId, jid)
+	if err := client.SendAppState(context.Background(), appstate.BuildArchive(jid, archive, ts, key)); err != nil {
+		return nil, err
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) {
+		s.Archived = archive
+		if archive {
+			s.Pinned = false
+		}
+	})
+	return map[string]interface{}{"success": true, "chat": jid.String(), "archived": archive}, nil
+}
+
+func SetChatPinned(userId string, chat string, pin bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SendAppState(context.Background(), appstate.BuildPin(jid, pin)); err != nil {
+		return nil, err
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) { s.Pinned = pin })
+	return map[string]interface{}{"success": true, "chat": jid.String(), "pinned": pin}, nil
+}
+
+// SetChatMuted mutes a chat for duration, or forever when duration is zero.
+// Unmuting ignores duration.
+func SetChatMuted(userId string, chat string, mute bool, duration time.Duration) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	if !mute {
+		duration = 0
+	}
+	if err := client.SendAppState(context.Background(), appstate.BuildMute(jid, mute, duration)); err != nil {
+		return nil, err
+	}
+
+	var mutedUntil int64
+	switch {
+	case mute && duration > 0:
+		mutedUntil = time.Now().Add(duration).UnixMilli()
+	case mute:
+		mutedUntil = -1
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) { s.MutedUntil = mutedUntil })
+
+	result := map[string]interface{}{"success": true, "chat": jid.String(), "muted": mute, "mutedUntil": nil}
+	if mutedUntil > 0 {
+		result["mutedUntil"] = time.UnixMilli(mutedUntil).UTC().Format(time.RFC3339)
+	}
+	return result, nil
+}
+
+// DeleteChat removes a chat from the chat list on all devices and drops its
+// messages from the log.
+func DeleteChat(userId string, chat string, deleteMedia bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	ts, key := lastMessage(userId, jid)
+	if err := client.SendAppState(context.Background(), appstate.BuildDeleteChat(jid, ts, key, deleteMedia)); err != nil {
+		return nil, err
+	}
+	removed := storage.ClearChatMessages(userId, jid.String(), true)
+	return map[string]interface{}{"success": true, "chat": jid.String(), "messagesRemoved": removed}, nil
+}
+
+// ClearChat empties a chat but keeps it in the chat list. Starred messages
+// are kept, as in the app's default.
+func ClearChat(userId string, chat string, deleteMedia bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	ts, key := lastMessage(userId, jid)
+	if err := client.SendAppState(context.Background(), buildClearChat(jid, ts, key, deleteMedia)); err != nil {
+		return nil, err
+	}
+	removed := storage.ClearChatMessages(userId, jid.String(), false)
+	return map[string]interface{}{"success": true, "chat": jid.String(), "messagesRemoved": removed}, nil
+}
+
+// buildClearChat is the clear-chat counterpart of appstate.BuildDeleteChat,
+// which whatsmeow doesn't provide. The index is
+// [clearChat, chat, keepStarred, deleteMedia].
+func buildClearChat(target types.JID, lastMessageTimestamp time.Time, lastMessageKey *waCommon.MessageKey, deleteMedia bool) appstate.PatchInfo {
+	if lastMessageTimestamp.IsZero() {
+		lastMessageTimestamp = time.Now()
+	}
+	messageRange := &waSyncAction.SyncActionMessageRange{
+		LastMessageTimestamp: proto.Int64(lastMessageTimestamp.Unix()),
+	}
+	if lastMessageKey != nil {
+		messageRange.Messages = []*waSyncAction.SyncActionMessage{{
+			Key:       lastMessageKey,
+			Timestamp: proto.Int64(lastMessageTimestamp.Unix()),
+		}}
+	}
+	deleteMediaFlag := "0"
+	if deleteMedia {
+		deleteMediaFlag = "1"
+	}
+	return appstate.PatchInfo{
+		Type: appstate.WAPatchRegularHigh,
+		Mutations: []appstate.MutationInfo{{
+			Index:   []string{appstate.IndexClearChat, target.String(), "1", deleteMediaFlag},
+			Version: 6,
+			Value: &waSyncAction.SyncActionValue{
+				ClearChatAction: &waSyncAction.ClearChatAction{MessageRange: messageRange},
+			},
+		}},
+	}
+}
+
+// updateChatState applies chat changes made on the phone or other linked
+// devices, including the initial app state sync after pairing.
+func updateChatState(userId string, evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Archive:
+		storage.UpdateChatState(userId, v.JID.String(), func(s *storage.ChatState) {
+			s.Archived = v.Action.GetArchived()
+			if s.Archived {
+				s.Pinned = false
+			}
+		})
+	case *events.Pin:
+		storage.UpdateChatState(userId, v.JID.String(), func(s *storage.ChatState) { s.Pinned = v.Action.GetPinned() })
+	case *events.Mute:
+		storage.UpdateChatState(userId, v.JID.String(), func(s *storage.ChatState) {
+			s.MutedUntil = 0
+			if v.Action.GetMuted() {
+				s.MutedUntil = v.Action.GetMuteEndTimestamp()
+				if s.MutedUntil == 0 {
+					s.MutedUntil = -1
+				}
+			}
+		})
+	case *events.MarkChatAsRead:
+		storage.UpdateChatState(userId, v.JID.String(), func(s *storage.ChatState) {
+			s.MarkedUnread = !v.Action.GetRead()
+			if v.Action.GetRead() {
+				s.ReadAt = v.Timestamp.UTC().Format(time.RFC3339)
+			}
+		})
+	case *events.Receipt:
+		// Read receipts we sent from another device mean the chat was read there
+		if v.IsFromMe && (v.Type == types.ReceiptTypeRead || v.Type == types.ReceiptTypeReadSelf) {
+			storage.UpdateChatState(userId, v.Chat.String(), func(s *storage.ChatState) {
+				if ts := v.Timestamp.UTC().Format(time.RFC3339); ts > s.ReadAt {
+					s.ReadAt = ts
+				}
+				s.MarkedUnread = false
+			})
+		}
+	case *events.DeleteChat:
+		storage.ClearChatMessages(userId, v.JID.String(), true)
+	case *events.ClearChat:
+		storage.ClearChatMessages(userId, v.JID.String(), false)
+	}
+}
@@ -1,10 +1,8 @@
 package whatsapp
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,7 +11,6 @@ import (
 
 	"wa-server-go/storage"
 
-	
 	_ "github.com/glebarez/sqlite"
 	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
@@ -23,8 +20,6 @@ import (
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
-
-	"net/http"
 )
 
 // ── Per-user client instances ──
@@ -64,6 +59,9 @@ func init() {
 	}
 }
 
+// GetUserClient returns the client state for an instance, creating it on
+// first use. The key is a user ID for personal instances or an organization
+// ID for shared ones.
 func GetUserClient(userId string) *ClientState {
 	clientsLock.RLock()
 	uc, ok := userClients[userId]
@@ -83,19 +81,66 @@ func GetUserClient(userId string) *ClientState {
 	return uc
 }
 
+// PeekUserClient returns the user's client state without creating one, for
+// callers (like the admin API) that only want to inspect it.
+func PeekUserClient(userId string) (*ClientState, bool) {
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+	uc, ok := userClients[userId]
+	return uc, ok
+}
 
+// RemoveUserClient shuts down and forgets the user's WhatsApp instance,
+// logging the linked device out. Used when the account itself is deleted.
+func RemoveUserClient(userId string) {
+	clientsLock.Lock()
+	uc, ok := userClients[userId]
+	delete(userClients, userId)
+	clientsLock.Unlock()
+	dropMetadata(userId)
+
+	if !ok {
+		return
+	}
+	if uc.CancelPairing != nil {
+		uc.CancelPairing()
+	}
+	if uc.Client != nil {
+		uc.Client.Logout(context.Background())
+		uc.Client.Disconnect()
+	}
+}
 
 // ── Event Handler ──
 
 func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 	return func(evt interface{}) {
+		updateMetadata(userId, evt)
+		updateChatState(userId, evt)
+		updateDisappearingTimers(userId, evt)
+
 		switch v := evt.(type) {
 		case *events.Message:
 			if v.Info.IsFromMe {
+				handleOwnMessage(userId, client, v)
+				return
+			}
+			if storage.IsIgnoredSender(userId, v.Info.Sender.User, v.Info.SenderAlt.User) {
+				return
+			}
+			if v.Info.Chat == types.StatusBroadcastJID {
+				handleStatus(userId, client, v)
+				return
+			}
+			// Timer changes, edits, revokes and key shares aren't chat messages
+			if v.Message.GetProtocolMessage() != nil || onlyKeyDistribution(v.Message) {
 				return
 			}
 			// Build message data matching JS format
-			contactName := v.Info.PushName
+			if v.Info.PushName != "" {
+				metadataFor(userId).updateContact(v.Info.Sender, func(n *contactNames) { n.PushName = v.Info.PushName })
+			}
+			contactName := resolveContactName(userId, client, v.Info.Sender)
 			if contactName == "" {
 				contactName = v.Info.Sender.User
 			}
@@ -103,25 +148,22 @@ func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 			isGroup := v.Info.IsGroup
 			var groupName *string
 			if isGroup {
-				g := v.Info.Sender.User // fallback
-				// To get real group name we'd need to query group info, omitting for speed or fetch from cache
+				g := resolveGroupName(userId, client, v.Info.Chat)
 				groupName = &g
 			}
 
-			var body string
-			if v.Message.GetConversation() != "" {
-				body = v.Message.GetConversation()
-			} else if v.Message.ExtendedTextMessage != nil {
-				body = v.Message.ExtendedTextMessage.GetText()
-			} else {
-				body = "Media/Other Message"
-			}
+			messageType, body := messageContent(v.Message)
 
 			messageData := map[string]interface{}{
 				"id":          v.Info.ID,
+				"chat":        v.Info.Chat.String(),
 				"from":        v.Info.Sender.ToNonAD().String(),
 				"to":          userId, // Not technically correct, but mimicking JS 'to'
 				"body":        body,
+				"messageType": messageType,
+				"isEphemeral": v.IsEphemeral,
+				"isViewOnce":  v.IsViewOnce,
+				"isForwarded": contextInfoOf(v.Message).GetIsForwarded(),
 				"timestamp":   v.Info.Timestamp.UTC().Format(time.RFC3339),
 				"type":        "received",
 				"contactName": contactName,
@@ -131,29 +173,34 @@ func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 
 			storage.PushToUserMessage(userId, messageData)
 			storage.IncrementStatUser(userId, "messagesReceived")
+			keepContent(userId, v.Info.ID, v.Message)
 
-			// Fire webhooks
-			userData := storage.LoadUser(userId)
-			for _, hook := range userData.Webhooks {
-				hookMap, ok := hook.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				urlStr, ok := hookMap["url"].(string)
-				if !ok {
-					continue
-				}
+			fireWebhooks(userId, messageData)
+			publish(userId, envelope("message.received", messageData))
 
-				payload, _ := json.Marshal(messageData)
-				go func(url string, body []byte) {
-					resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
-					if err != nil {
-						fmt.Printf("Webhook failed (%s): %v\n", url, err)
-					} else if resp != nil {
-						resp.Body.Close()
-					}
-				}(urlStr, payload)
+		case *events.GroupInfo:
+			handleGroupInfo(userId, v)
+
+		case *events.JoinedGroup:
+			handleJoinedGroup(userId, v)
+
+		case *events.Picture:
+			handleGroupPicture(userId, v)
+
+		case *events.Blocklist:
+			handleBlocklist(userId, v)
+
+		case *events.CallOffer, *events.CallOfferNotice, *events.CallAccept, *events.CallReject, *events.CallTerminate:
+			handleCall(userId, client, v)
+
+		case *events.Presence:
+			handlePresence(userId, v)
+
+		case *events.ChatPresence:
+			if storage.IsIgnoredSender(userId, v.Sender.User, v.SenderAlt.User) {
+				return
 			}
+			handleChatPresence(userId, v)
 
 		case *events.Connected:
 			uc := GetUserClient(userId)
@@ -166,8 +213,10 @@ func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 					Phone:    client.Store.ID.User,
 					Platform: "whatsmeow",
 				}
+				storage.SetLinkedPhone(userId, uc.ClientInfo.Phone)
 				fmt.Printf("✅ [%.8s] WhatsApp connected as %s (%s)\n", userId, uc.ClientInfo.PushName, uc.ClientInfo.Phone)
 			}
+			go warmGroupNames(userId, client)
 
 		case *events.Disconnected:
 			uc := GetUserClient(userId)
@@ -182,6 +231,8 @@ func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 			uc := GetUserClient(userId)
 			uc.ConnectionStatus = "disconnected"
 			storage.ClearUserBotData(userId)
+			storage.SetLinkedPhone(userId, "")
+			dropMetadata(userId)
 			client.Disconnect()
 
 		case *events.PairSuccess:
@@ -190,6 +241,68 @@ func eventHandler(userId string, client *whatsmeow.Client) func(interface{}) {
 	}
 }
 
+// handleOwnMessage logs messages the account sent from the phone or another
+// linked device. Sends made through this server aren't echoed back by
+// WhatsApp, but any that are (matched by device or message ID) are skipped
+// so they aren't logged twice.
+func handleOwnMessage(userId string, client *whatsmeow.Client, v *events.Message) {
+	if client.Store.ID != nil && v.Info.Sender.Device == client.Store.ID.Device {
+		return
+	}
+	// Protocol messages (key shares, history sync, edits, revokes),
+	// reactions and bare group key distributions aren't chat messages
+	if v.Message.GetProtocolMessage() != nil || v.Message.GetReactionMessage() != nil || onlyKeyDistribution(v.Message) {
+		return
+	}
+	if v.Info.Chat == types.StatusBroadcastJID {
+		return
+	}
+	if storage.HasMessage(userId, v.Info.ID) {
+		return
+	}
+
+	messageType, body := messageContent(v.Message)
+	origin := "linked_device"
+	if v.Info.Sender.Device == 0 {
+		origin = "phone"
+	}
+
+	chat := v.Info.Chat
+	var groupName interface{}
+	contactName := "Group"
+	if v.Info.IsGroup {
+		groupName = resolveGroupName(userId, client, chat)
+	} else {
+		contactName = resolveContactName(userId, client, chat)
+		if contactName == "" {
+			contactName = chat.User
+		}
+	}
+
+	messageData := map[string]interface{}{
+		"id":          v.Info.ID,
+		"chat":        chat.String(),
+		"from":        "me",
+		"to":          chat.String(),
+		"body":        body,
+		"messageType": messageType,
+		"isEphemeral": v.IsEphemeral,
+		"isViewOnce":  v.IsViewOnce,
+		"isForwarded": contextInfoOf(v.Message).GetIsForwarded(),
+		"timestamp":   v.Info.Timestamp.UTC().Format(time.RFC3339),
+		"type":        "sent",
+		"origin":      origin,
+		"contactName": contactName,
+		"isGroup":     v.Info.IsGroup,
+		"groupName":   groupName,
+	}
+
+	storage.PushToUserMessage(userId, messageData)
+	storage.IncrementStatUser(userId, "messagesSent")
+	keepContent(userId, v.Info.ID, v.Message)
+	emitEvent(userId, "message.sent_from_device", messageData)
+}
+
 // ── Operations ──
 
 func Initialize(userId string, method string, phoneNumber string) error {
@@ -306,82 +419,149 @@ func Disconnect(userId string) error {
 	uc.ClientInfo = nil
 	uc.LastError = nil
 	storage.ClearUserBotData(userId)
+	storage.SetLinkedPhone(userId, "")
 	fmt.Printf("🔌 [%.8s] WhatsApp disconnected by user\n", userId)
 	return nil
 }
 
 // --- Endpoints mapping ---
 
-func SendMessage(userId string, number string, message string) (interface{}, error) {
+// verifyRecipient checks that a phone number is on WhatsApp when the server
+// is configured to reject unregistered numbers, and returns its canonical
+// JID. Other recipients pass through unchanged.
+func verifyRecipient(client *whatsmeow.Client, jid types.JID) (types.JID, error) {
+	if !storage.GetGlobalConfig().Config.RejectUnregisteredNumbers || jid.Server != types.DefaultUserServer {
+		return jid, nil
+	}
+	found, err := lookupNumber(client, jid.User)
+	if err != nil {
+		return jid, err
+	}
+	if !found.IsIn {
+		return jid, fmt.Errorf("%w: %s", ErrNotOnWhatsApp, jid.User)
+	}
+	return found.JID, nil
+}
+
+// SendOptions tunes how an outgoing message is delivered.
+type SendOptions struct {
+	// Typing shows "typing…" in the chat before sending, for a time
+	// proportional to the message length.
+	Typing bool
+	// Ephemeral overrides the chat's disappearing timer for this message,
+	// in seconds; 0 sends a normal message. Nil follows the chat's timer.
+	Ephemeral *uint32
+	// ViewOnce sends media that can only be opened once. Ignored for text.
+	ViewOnce bool
+	// NoLinkPreview sends text without a preview card for its first link.
+	NoLinkPreview bool
+}
+
+func SendMessage(userId string, number string, message string, opts SendOptions) (interface{}, error) {
 	uc := GetUserClient(userId)
 	if uc.Client == nil || !uc.Client.IsConnected() {
 		return nil, fmt.Errorf("WhatsApp client is not connected")
 	}
 
-	jid := types.NewJID(number, types.DefaultUserServer)
-	if resp, err := uc.Client.IsOnWhatsApp(context.Background(), []string{jid.String()}); err == nil && len(resp) > 0 {
-		if !resp[0].IsIn {
-			// Just assuming it works for now
-		}
+	jid, err := ParseUserJID(number)
+	if err != nil {
+		return nil, err
+	}
+	if jid, err = verifyRecipient(uc.Client, jid); err != nil {
+		return nil, err
 	}
 
-	msgId := whatsmeow.GenerateMessageID()
-	resp, err := uc.Client.SendMessage(context.Background(), jid, &waProto.Message{
-		Conversation: &message,
-	})
+	if opts.Typing {
+		simulateTyping(uc.Client, jid, message)
+	}
+
+	msg := &waProto.Message{Conversation: &message}
+	if !opts.NoLinkPreview {
+		addLinkPreview(msg)
+	}
+	expiration := expirationFor(userId, jid, opts.Ephemeral)
+	applyExpiration(msg, expiration)
+	resp, err := uc.Client.SendMessage(context.Background(), jid, msg)
 
 	if err != nil {
 		return nil, err
 	}
 
+	contactName := resolveContactName(userId, uc.Client, jid)
+	if contactName == "" {
+		contactName = jid.User
+	}
+
 	messageData := map[string]interface{}{
-		"id":          msgId,
+		"id":          resp.ID,
+		"chat":        jid.String(),
 		"from":        "me",
 		"to":          jid.String(),
 		"body":        message,
+		"messageType": "text",
+		"isEphemeral": expiration > 0,
+		"isViewOnce":  false,
 		"timestamp":   resp.Timestamp.UTC().Format(time.RFC3339),
 		"type":        "sent",
-		"contactName": number,
+		"origin":      "api",
+		"contactName": contactName,
 		"isGroup":     false,
 		"groupName":   nil,
 	}
 
 	storage.PushToUserMessage(userId, messageData)
 	storage.IncrementStatUser(userId, "messagesSent")
+	keepContent(userId, resp.ID, msg)
 
 	return messageData, nil
 }
 
-func SendGroupMessage(userId string, groupId string, message string) (interface{}, error) {
+func SendGroupMessage(userId string, groupId string, message string, opts SendOptions) (interface{}, error) {
 	uc := GetUserClient(userId)
 	if uc.Client == nil || !uc.Client.IsConnected() {
 		return nil, fmt.Errorf("WhatsApp client is not connected")
 	}
 
-	jid := types.NewJID(groupId, types.GroupServer)
-	msgId := whatsmeow.GenerateMessageID()
-	resp, err := uc.Client.SendMessage(context.Background(), jid, &waProto.Message{
-		Conversation: &message,
-	})
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Typing {
+		simulateTyping(uc.Client, jid, message)
+	}
+
+	msg := &waProto.Message{Conversation: &message}
+	if !opts.NoLinkPreview {
+		addLinkPreview(msg)
+	}
+	expiration := expirationFor(userId, jid, opts.Ephemeral)
+	applyExpiration(msg, expiration)
+	resp, err := uc.Client.SendMessage(context.Background(), jid, msg)
 
 	if err != nil {
 		return nil, err
 	}
 
 	messageData := map[string]interface{}{
-		"id":          msgId,
+		"id":          resp.ID,
+		"chat":        jid.String(),
 		"from":        "me",
 		"to":          jid.String(),
 		"body":        message,
+		"messageType": "text",
+		"isEphemeral": expiration > 0,
+		"isViewOnce":  false,
 		"timestamp":   resp.Timestamp.UTC().Format(time.RFC3339),
 		"type":        "sent",
+		"origin":      "api",
 		"contactName": "Group",
 		"isGroup":     true,
-		"groupName":   groupId,
+		"groupName":   resolveGroupName(userId, uc.Client, jid),
 	}
 
 	storage.PushToUserMessage(userId, messageData)
 	storage.IncrementStatUser(userId, "messagesSent")
+	keepContent(userId, resp.ID, msg)
 
 	return messageData, nil
 }
@@ -398,7 +578,9 @@ func GetGroups(userId string) ([]interface{}, error) {
 	}
 
 	result := make([]interface{}, 0)
+	m := metadataFor(userId)
 	for _, g := range groups {
+		m.setGroupName(g.JID, g.Name)
 		result = append(result, map[string]interface{}{
 			"id":               g.JID.User,
 			"name":             g.Name,
@@ -429,30 +611,57 @@ func LeaveGroup(userId string, groupId string) (interface{}, error) {
 		return nil, fmt.Errorf("WhatsApp client is not connected")
 	}
 
-	jid := types.NewJID(groupId, types.GroupServer)
-	err := uc.Client.LeaveGroup(context.Background(), jid)
+	jid, err := ParseGroupJID(groupId)
 	if err != nil {
 		return nil, err
 	}
+	if err := uc.Client.LeaveGroup(context.Background(), jid); err != nil {
+		return nil, err
+	}
 	storage.IncrementStatUser(userId, "groupsLeft")
-	return map[string]interface{}{"success": true, "groupId": groupId}, nil
+	return map[string]interface{}{"success": true, "groupId": jid.User}, nil
 }
 
+// AddToGroup adds participants to a group; see UpdateParticipants for the
+// per-participant results.
 func AddToGroup(userId string, groupId string, participants []string) (interface{}, error) {
-	uc := GetUserClient(userId)
-	if uc.Client == nil || !uc.Client.IsConnected() {
-		return nil, fmt.Errorf("WhatsApp client is not connected")
+	outcomes, err := UpdateParticipants(userId, groupId, participants, string(whatsmeow.ParticipantChangeAdd))
+	if err != nil {
+		return nil, err
 	}
+	return map[string]interface{}{"success": true, "participants": outcomes}, nil
+}
+
+// LinkedPhone returns the phone number of the WhatsApp account linked to the
+// user's instance, or "" if none is linked. It doesn't load the instance:
+// when it isn't running, the number recorded at its last connection is used.
+func LinkedPhone(userId string) string {
+	if uc, ok := PeekUserClient(userId); ok {
+		if uc.ClientInfo != nil && uc.ClientInfo.Phone != "" {
+			return uc.ClientInfo.Phone
+		}
+		if uc.Client != nil && uc.Client.Store.ID != nil {
+			return uc.Client.Store.ID.User
+		}
+	}
+	return storage.GetLinkedPhone(userId)
+}
 
-	jids := make([]types.JID, 0)
-	for _, p := range participants {
-		jids = append(jids, types.NewJID(p, types.DefaultUserServer))
+// SendNotification sends a system text (e.g. a password reset code) from the
+// user's instance. Unlike SendMessage it isn't recorded in the message log
+// or stats.
+func SendNotification(userId string, number string, message string) error {
+	uc := GetUserClient(userId)
+	if uc.Client == nil || !uc.Client.IsConnected() {
+		return fmt.Errorf("WhatsApp client is not connected")
 	}
 
-	groupID := types.NewJID(groupId, types.GroupServer)
-	_, err := uc.Client.UpdateGroupParticipants(context.Background(), groupID, jids, whatsmeow.ParticipantChangeAdd)
+	jid, err := ParseUserJID(number)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return map[string]interface{}{"success": true}, nil
+	_, err = uc.Client.SendMessage(context.Background(), jid, &waProto.Message{
+		Conversation: &message,
+	})
+	return err
 }
@@ -0,0 +1,37 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"wa-server-go/storage"
+)
+
+func TestLinkedPhoneDoesNotLoadInstance(t *testing.T) {
+	userId := "11111111-2222-3333-4444-555555555555"
+	storage.SetLinkedPhone(userId, "254712345678")
+	defer storage.DeleteUserData(userId)
+
+	if got := LinkedPhone(userId); got != "254712345678" {
+		t.Errorf("LinkedPhone = %q, want the stored number", got)
+	}
+	if _, ok := PeekUserClient(userId); ok {
+		t.Error("LinkedPhone created a client entry")
+	}
+
+	uc := GetUserClient(userId)
+	uc.ClientInfo = &ClientInfo{Phone: "254700000000"}
+	defer func() {
+		clientsLock.Lock()
+		delete(userClients, userId)
+		clientsLock.Unlock()
+	}()
+	if got := LinkedPhone(userId); got != "254700000000" {
+		t.Errorf("LinkedPhone = %q, want the connected number", got)
+	}
+
+	storage.SetLinkedPhone(userId, "")
+	uc.ClientInfo = nil
+	if got := LinkedPhone(userId); got != "" {
+		t.Errorf("LinkedPhone after logout = %q", got)
+	}
+}
@@ -0,0 +1,282 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ErrNotOnWhatsApp is returned when sending to a number that isn't
+// registered on WhatsApp and recipient verification is enabled.
+var ErrNotOnWhatsApp = errors.New("number is not on WhatsApp")
+
+func verifiedName(v *types.VerifiedName) string {
+	if v == nil || v.Details == nil {
+		return ""
+	}
+	return v.Details.GetVerifiedName()
+}
+
+// lookupNumber asks WhatsApp whether number is registered and returns its
+// canonical JID.
+func lookupNumber(client *whatsmeow.Client, number string) (types.IsOnWhatsAppResponse, error) {
+	phone, err := NormalizePhone(number)
+	if err != nil {
+		return types.IsOnWhatsAppResponse{}, err
+	}
+	resp, err := client.IsOnWhatsApp(context.Background(), []string{"+" + phone})
+	if err != nil {
+		return types.IsOnWhatsAppResponse{}, err
+	}
+	if len(resp) == 0 {
+		return types.IsOnWhatsAppResponse{Query: number}, nil
+	}
+	return resp[0], nil
+}
+
+// CheckNumbers reports which of the given phone numbers are registered on
+// WhatsApp. Numbers that can't be parsed are reported with an error rather
+// than failing the whole batch.
+func CheckNumbers(userId string, numbers []string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := make([]string, len(numbers))
+	phones := make([]string, 0, len(numbers))
+	for i, n := range numbers {
+		if phone, err := NormalizePhone(n); err == nil {
+			normalized[i] = phone
+			phones = append(phones, "+"+phone)
+		}
+	}
+	found := make(map[string]types.IsOnWhatsAppResponse, len(phones))
+	if len(phones) > 0 {
+		resp, err := client.IsOnWhatsApp(context.Background(), phones)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp {
+			found[strings.TrimPrefix(r.Query, "+")] = r
+		}
+	}
+
+	result := make([]interface{}, 0, len(numbers))
+	for i, n := range numbers {
+		if normalized[i] == "" {
+			_, err := NormalizePhone(n)
+			result = append(result, map[string]interface{}{
+				"number":       n,
+				"valid":        false,
+				"isOnWhatsApp": false,
+				"error":        err.Error(),
+			})
+			continue
+		}
+		r, ok := found[normalized[i]]
+		entry := map[string]interface{}{
+			"number":       n,
+			"normalized":   normalized[i],
+			"valid":        true,
+			"isOnWhatsApp": ok && r.IsIn,
+		}
+		if ok && r.IsIn {
+			entry["jid"] = r.JID.String()
+			if name := verifiedName(r.VerifiedName); name != "" {
+				entry["businessName"] = name
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// GetContacts lists the contacts known to the linked device, sorted by name.
+func GetContacts(userId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		name string
+		view map[string]interface{}
+	}
+	entries := make([]entry, 0, len(contacts))
+	for jid, c := range contacts {
+		if jid.Server != types.DefaultUserServer {
+			continue
+		}
+		name := contactNames{FullName: c.FullName, PushName: c.PushName, BusinessName: c.BusinessName}.best()
+		entries = append(entries, entry{name, map[string]interface{}{
+			"number":       jid.User,
+			"jid":          jid.String(),
+			"name":         name,
+			"fullName":     c.FullName,
+			"pushName":     c.PushName,
+			"businessName": c.BusinessName,
+		}})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].name) < strings.ToLower(entries[j].name)
+	})
+
+	result := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.view)
+	}
+	return result, nil
+}
+
+// GetContactProfile returns a contact's about text, business details and
+// profile picture URL, as far as their privacy settings allow.
+func GetContactProfile(userId string, number string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := lookupNumber(client, number)
+	if err != nil {
+		return nil, err
+	}
+	if !found.IsIn {
+		return nil, fmt.Errorf("%w: %s", ErrNotOnWhatsApp, number)
+	}
+	jid := found.JID
+
+	profile := map[string]interface{}{
+		"number":     jid.User,
+		"jid":        jid.String(),
+		"name":       resolveContactName(userId, client, jid),
+		"isBusiness": found.VerifiedName != nil,
+	}
+
+	if info, err := client.GetUserInfo(context.Background(), []types.JID{jid}); err == nil {
+		if u, ok := info[jid]; ok {
+			profile["about"] = u.Status
+		}
+	}
+
+	if found.VerifiedName != nil {
+		business := map[string]interface{}{"name": verifiedName(found.VerifiedName)}
+		if bp, err := client.GetBusinessProfile(context.Background(), jid); err == nil {
+			categories := make([]string, 0, len(bp.Categories))
+			for _, c := range bp.Categories {
+				categories = append(categories, c.Name)
+			}
+			business["address"] = bp.Address
+			business["email"] = bp.Email
+			business["categories"] = categories
+			business["website"] = bp.ProfileOptions["website"]
+		}
+		profile["business"] = business
+	}
+
+	if pic, err := client.GetProfilePictureInfo(context.Background(), jid, &whatsmeow.GetProfilePictureParams{}); err == nil && pic != nil {
+		profile["pictureUrl"] = pic.URL
+		profile["pictureId"] = pic.ID
+	} else {
+		profile["pictureUrl"] = nil
+	}
+	return profile, nil
+}
+
+// GetProfilePicture downloads a contact's profile picture, or its thumbnail
+// when preview is set. It returns nil if the contact has none or hides it.
+func GetProfilePicture(userId string, number string, preview bool) ([]byte, string, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	found, err := lookupNumber(client, number)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found.IsIn {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotOnWhatsApp, number)
+	}
+
+	pic, err := client.GetProfilePictureInfo(context.Background(), found.JID, &whatsmeow.GetProfilePictureParams{Preview: preview})
+	if errors.Is(err, whatsmeow.ErrProfilePictureNotSet) || errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) || (err == nil && pic == nil) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	resp, err := httpClient.Get(pic.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("profile picture download failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// ── Blocklist ──
+
+func GetBlocklist(userId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	blocklist, err := client.GetBlocklist(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(blocklist.JIDs))
+	for _, jid := range blocklist.JIDs {
+		result = append(result, map[string]interface{}{
+			"number": jid.User,
+			"jid":    jid.String(),
+			"name":   resolveContactName(userId, client, jid),
+		})
+	}
+	return result, nil
+}
+
+// SetBlocked blocks or unblocks a contact on WhatsApp.
+func SetBlocked(userId string, number string, block bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseUserJID(number)
+	if err != nil {
+		return nil, err
+	}
+	action := events.BlocklistChangeActionUnblock
+	if block {
+		action = events.BlocklistChangeActionBlock
+	}
+	if _, err := client.UpdateBlocklist(context.Background(), jid, action); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "number": jid.User, "blocked": block}, nil
+}
@@ -0,0 +1,301 @@
+package whatsapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// fireWebhooks posts payload as JSON to every webhook registered for the
+// instance. Deliveries run in the background and failures are only logged.
+func fireWebhooks(userId string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	userData := storage.LoadUser(userId)
+	for _, hook := range userData.Webhooks {
+		hookMap, ok := hook.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		urlStr, ok := hookMap["url"].(string)
+		if !ok {
+			continue
+		}
+
+		go func(url string, body []byte) {
+			resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				fmt.Printf("Webhook failed (%s): %v\n", url, err)
+			} else if resp != nil {
+				resp.Body.Close()
+			}
+		}(urlStr, body)
+	}
+}
+
+func envelope(event string, data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"data":      data,
+	}
+}
+
+// emitEvent delivers a non-message event to the instance's webhooks and
+// event stream as {"event": ..., "timestamp": ..., "data": ...}. Incoming
+// messages keep their original flat payload on webhooks.
+func emitEvent(userId string, event string, data map[string]interface{}) {
+	payload := envelope(event, data)
+	fireWebhooks(userId, payload)
+	publish(userId, payload)
+}
+
+// ── Event stream ──
+
+var (
+	subscribers      = make(map[string]map[chan []byte]struct{})
+	subscribersMutex = &sync.Mutex{}
+)
+
+// Subscribe returns a channel receiving every event emitted for the
+// instance as JSON, and a function to stop the subscription. Slow
+// subscribers miss events rather than blocking delivery.
+func Subscribe(userId string) (<-chan []byte, func()) {
+	ch := make(chan []byte, 32)
+	subscribersMutex.Lock()
+	if subscribers[userId] == nil {
+		subscribers[userId] = make(map[chan []byte]struct{})
+	}
+	subscribers[userId][ch] = struct{}{}
+	subscribersMutex.Unlock()
+
+	return ch, func() {
+		subscribersMutex.Lock()
+		defer subscribersMutex.Unlock()
+		if _, ok := subscribers[userId][ch]; ok {
+			delete(subscribers[userId], ch)
+			if len(subscribers[userId]) == 0 {
+				delete(subscribers, userId)
+			}
+			close(ch)
+		}
+	}
+}
+
+func publish(userId string, payload interface{}) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	if len(subscribers[userId]) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	for ch := range subscribers[userId] {
+		select {
+		case ch <- body:
+		default:
+		}
+	}
+}
+
+// ── Group events ──
+
+func jidNumbers(jids []types.JID) []string {
+	numbers := make([]string, 0, len(jids))
+	for _, j := range jids {
+		numbers = append(numbers, j.User)
+	}
+	return numbers
+}
+
+// groupActor returns the phone number of whoever made a group change.
+func groupActor(sender *types.JID, senderPN *types.JID) string {
+	if senderPN != nil && !senderPN.IsEmpty() {
+		return senderPN.User
+	}
+	if sender != nil {
+		return sender.User
+	}
+	return ""
+}
+
+// recordGroupEvent logs a group change to the group's activity log and
+// emits it to webhooks and the event stream.
+func recordGroupEvent(userId string, activity storage.GroupActivity) {
+	storage.RecordGroupActivity(userId, activity)
+
+	data := map[string]interface{}{"groupId": activity.GroupID}
+	if name, ok := metadataFor(userId).groupName(types.NewJID(activity.GroupID, types.GroupServer)); ok {
+		data["groupName"] = name
+	}
+	if activity.Actor != "" {
+		data["actor"] = activity.Actor
+	}
+	if len(activity.Participants) > 0 {
+		data["participants"] = activity.Participants
+	}
+	for k, v := range activity.Details {
+		data[k] = v
+	}
+	emitEvent(userId, activity.Event, data)
+}
+
+func linkDetails(change *types.GroupLinkChange) map[string]interface{} {
+	return map[string]interface{}{
+		"linkType":       string(change.Type),
+		"linkedGroupId":  change.Group.JID.User,
+		"linkedGroup":    change.Group.Name,
+		"isAnnouncement": change.Group.IsDefaultSubGroup,
+	}
+}
+
+// handleGroupInfo splits a group notification into one normalized event
+// per change.
+func handleGroupInfo(userId string, v *events.GroupInfo) {
+	base := storage.GroupActivity{
+		Time:    v.Timestamp.UTC().Format(time.RFC3339),
+		GroupID: v.JID.User,
+		Actor:   groupActor(v.Sender, v.SenderPN),
+	}
+	record := func(event string, participants []types.JID, details map[string]interface{}) {
+		a := base
+		a.Event = event
+		if participants != nil {
+			a.Participants = jidNumbers(participants)
+		}
+		a.Details = details
+		recordGroupEvent(userId, a)
+	}
+
+	if len(v.Join) > 0 {
+		details := map[string]interface{}{}
+		if v.JoinReason != "" {
+			details["reason"] = v.JoinReason
+		}
+		record("group.participants_added", v.Join, details)
+	}
+	if len(v.Leave) > 0 {
+		record("group.participants_removed", v.Leave, nil)
+	}
+	if len(v.Promote) > 0 {
+		record("group.participants_promoted", v.Promote, nil)
+	}
+	if len(v.Demote) > 0 {
+		record("group.participants_demoted", v.Demote, nil)
+	}
+	if v.Name != nil {
+		record("group.subject_changed", nil, map[string]interface{}{"name": v.Name.Name})
+	}
+	if v.Topic != nil {
+		record("group.description_changed", nil, map[string]interface{}{
+			"description": v.Topic.Topic,
+			"deleted":     v.Topic.TopicDeleted,
+		})
+	}
+
+	settings := map[string]interface{}{}
+	if v.Announce != nil {
+		settings["announce"] = v.Announce.IsAnnounce
+	}
+	if v.Locked != nil {
+		settings["locked"] = v.Locked.IsLocked
+	}
+	if v.Ephemeral != nil {
+		settings["ephemeral"] = v.Ephemeral.IsEphemeral
+		settings["ephemeralTimer"] = v.Ephemeral.DisappearingTimer
+	}
+	if v.MembershipApprovalMode != nil {
+		settings["approvalRequired"] = v.MembershipApprovalMode.IsJoinApprovalRequired
+	}
+	if len(settings) > 0 {
+		record("group.settings_changed", nil, settings)
+	}
+
+	if v.NewInviteLink != nil {
+		record("group.invite_link_changed", nil, map[string]interface{}{"inviteLink": *v.NewInviteLink})
+	}
+	if v.Link != nil {
+		record("community.group_linked", nil, linkDetails(v.Link))
+	}
+	if v.Unlink != nil {
+		details := linkDetails(v.Unlink)
+		if v.Unlink.UnlinkReason != "" {
+			details["reason"] = string(v.Unlink.UnlinkReason)
+		}
+		record("community.group_unlinked", nil, details)
+	}
+	if v.Delete != nil {
+		record("group.deleted", nil, map[string]interface{}{"reason": v.Delete.DeleteReason})
+	}
+}
+
+// handleJoinedGroup records the instance's own account being added to (or
+// creating) a group.
+func handleJoinedGroup(userId string, v *events.JoinedGroup) {
+	details := map[string]interface{}{
+		"name":             v.Name,
+		"participantCount": len(v.Participants),
+	}
+	if v.Reason != "" {
+		details["reason"] = v.Reason
+	}
+	if v.Type != "" {
+		details["type"] = v.Type
+	}
+	recordGroupEvent(userId, storage.GroupActivity{
+		GroupID: v.JID.User,
+		Event:   "group.joined",
+		Actor:   groupActor(v.Sender, v.SenderPN),
+		Details: details,
+	})
+}
+
+// handleGroupPicture records a group photo change. Profile picture changes
+// of contacts are ignored.
+func handleGroupPicture(userId string, v *events.Picture) {
+	if v.JID.Server != types.GroupServer {
+		return
+	}
+	recordGroupEvent(userId, storage.GroupActivity{
+		Time:    v.Timestamp.UTC().Format(time.RFC3339),
+		GroupID: v.JID.User,
+		Event:   "group.photo_changed",
+		Actor:   v.Author.User,
+		Details: map[string]interface{}{"removed": v.Remove, "pictureId": v.PictureID},
+	})
+}
+
+// ── Blocklist events ──
+
+// handleBlocklist reports blocklist changes made from any device. A
+// "modify" notification carries no details, so it's forwarded as-is for
+// consumers to re-fetch the list.
+func handleBlocklist(userId string, v *events.Blocklist) {
+	if v.Action == events.BlocklistActionModify {
+		emitEvent(userId, "blocklist.changed", map[string]interface{}{})
+		return
+	}
+	for _, change := range v.Changes {
+		event := "contact.unblocked"
+		if change.Action == events.BlocklistChangeActionBlock {
+			event = "contact.blocked"
+		}
+		emitEvent(userId, event, map[string]interface{}{
+			"number": change.JID.User,
+			"jid":    change.JID.String(),
+		})
+	}
+}
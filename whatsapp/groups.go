@@ -0,0 +1,666 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	groupPhotoSize = 640
+	// groupPhotoMaxSide bounds uploads by their header before decoding, so a
+	// small file claiming huge dimensions can't exhaust memory.
+	groupPhotoMaxSide = 8192
+)
+
+// connectedClient returns the instance's whatsmeow client, or an error if it
+// isn't connected.
+func connectedClient(userId string) (*whatsmeow.Client, error) {
+	uc := GetUserClient(userId)
+	if uc.Client == nil || !uc.Client.IsConnected() {
+		return nil, fmt.Errorf("WhatsApp client is not connected")
+	}
+	return uc.Client, nil
+}
+
+// groupPhotoJPEG converts an uploaded image into the square JPEG WhatsApp
+// expects for group photos, center-cropping and downscaling as needed.
+func groupPhotoJPEG(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %v", err)
+	}
+	if cfg.Width > groupPhotoMaxSide || cfg.Height > groupPhotoMaxSide {
+		return nil, fmt.Errorf("image is too large (%dx%d, max %dx%d)", cfg.Width, cfg.Height, groupPhotoMaxSide, groupPhotoMaxSide)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %v", err)
+	}
+
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	if side == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	out := side
+	if out > groupPhotoSize {
+		out = groupPhotoSize
+	}
+	// Nearest-neighbour scaling is plenty for a 640px avatar
+	dst := image.NewRGBA(image.Rect(0, 0, out, out))
+	for y := 0; y < out; y++ {
+		for x := 0; x < out; x++ {
+			dst.Set(x, y, src.At(x0+x*side/out, y0+y*side/out))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ── Group metadata ──
+
+// CreateGroup creates a group with the given participants. When
+// communityId is set the group is created inside that community.
+func CreateGroup(userId string, name string, participants []string, communityId string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jids, err := ParseUserJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+
+	req := whatsmeow.ReqCreateGroup{
+		Name:         name,
+		Participants: jids,
+	}
+	if communityId != "" {
+		if req.LinkedParentJID, err = ParseGroupJID(communityId); err != nil {
+			return nil, err
+		}
+	}
+	info, err := client.CreateGroup(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	storage.IncrementStatUser(userId, "groupsJoined")
+	metadataFor(userId).setGroupName(info.JID, info.Name)
+
+	members := make([]string, 0, len(info.Participants))
+	for _, p := range info.Participants {
+		members = append(members, p.JID.ToNonAD().String())
+	}
+	result := map[string]interface{}{
+		"id":               info.JID.User,
+		"jid":              info.JID.String(),
+		"name":             info.Name,
+		"participants":     members,
+		"participantCount": len(info.Participants),
+		"createdAt":        info.GroupCreated.UTC().Format(time.RFC3339),
+	}
+	if communityId != "" {
+		result["communityId"] = req.LinkedParentJID.User
+	}
+	emitEvent(userId, "group.created", result)
+	return result, nil
+}
+
+func SetGroupName(userId string, groupId string, name string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetGroupName(context.Background(), jid, name); err != nil {
+		return nil, err
+	}
+	metadataFor(userId).setGroupName(jid, name)
+	result := map[string]interface{}{"groupId": jid.User, "name": name}
+	return result, nil
+}
+
+// SetGroupDescription sets the group description; an empty description
+// clears it.
+func SetGroupDescription(userId string, groupId string, description string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetGroupTopic(context.Background(), jid, "", "", description); err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"groupId": jid.User, "description": description}
+	return result, nil
+}
+
+// SetGroupPhoto replaces the group photo with the given image (any format
+// the image package can decode); nil removes the photo.
+func SetGroupPhoto(userId string, groupId string, img []byte) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var avatar []byte
+	if img != nil {
+		if avatar, err = groupPhotoJPEG(img); err != nil {
+			return nil, err
+		}
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	pictureId, err := client.SetGroupPhoto(context.Background(), jid, avatar)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"groupId": jid.User, "pictureId": pictureId, "removed": img == nil}
+	return result, nil
+}
+
+// UpdateGroupSettings toggles announce-only (only admins can send) and
+// locked-info (only admins can edit group info). Nil leaves a setting as is.
+func UpdateGroupSettings(userId string, groupId string, announce *bool, locked *bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"groupId": jid.User}
+	if announce != nil {
+		if err := client.SetGroupAnnounce(context.Background(), jid, *announce); err != nil {
+			return nil, err
+		}
+		result["announce"] = *announce
+	}
+	if locked != nil {
+		if err := client.SetGroupLocked(context.Background(), jid, *locked); err != nil {
+			return nil, err
+		}
+		result["locked"] = *locked
+	}
+	return result, nil
+}
+
+// ── Participants ──
+
+// participantStatuses names the error codes WhatsApp returns for individual
+// participants in a group update.
+var participantStatuses = map[int]string{
+	0:   "ok",
+	200: "ok",
+	401: "blocked",
+	403: "invite_required",
+	404: "not_on_whatsapp",
+	408: "recently_left",
+	409: "already_member",
+	500: "group_full",
+}
+
+func participantNumber(p types.GroupParticipant) string {
+	if !p.PhoneNumber.IsEmpty() {
+		return p.PhoneNumber.User
+	}
+	return p.JID.User
+}
+
+func participantOutcome(p types.GroupParticipant) map[string]interface{} {
+	status, ok := participantStatuses[p.Error]
+	if !ok {
+		status = "failed"
+	}
+	return map[string]interface{}{
+		"number": participantNumber(p),
+		"jid":    p.JID.String(),
+		"status": status,
+		"code":   p.Error,
+	}
+}
+
+// sendGroupInvite messages a participant whose privacy settings refused a
+// direct add, using the invite code WhatsApp returned for them.
+func sendGroupInvite(client *whatsmeow.Client, group types.JID, groupName string, p types.GroupParticipant) error {
+	to := p.JID
+	if !p.PhoneNumber.IsEmpty() {
+		to = p.PhoneNumber
+	}
+	groupJid := group.String()
+	caption := "Invitation to join my WhatsApp group"
+	expiration := p.AddRequest.Expiration.Unix()
+	_, err := client.SendMessage(context.Background(), to, &waProto.Message{
+		GroupInviteMessage: &waProto.GroupInviteMessage{
+			GroupJID:         &groupJid,
+			InviteCode:       &p.AddRequest.Code,
+			InviteExpiration: &expiration,
+			GroupName:        &groupName,
+			Caption:          &caption,
+		},
+	})
+	return err
+}
+
+// UpdateParticipants adds, removes, promotes or demotes group participants
+// and reports the outcome for each one. Participants who can only be added
+// by invitation are sent a group invite message instead.
+func UpdateParticipants(userId string, groupId string, participants []string, action string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	change := whatsmeow.ParticipantChange(action)
+	switch change {
+	case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove,
+		whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+	default:
+		return nil, fmt.Errorf("action must be add, remove, promote or demote")
+	}
+	if len(participants) == 0 {
+		return nil, fmt.Errorf("participants are required")
+	}
+
+	jids, err := ParseUserJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+
+	groupJid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := client.UpdateGroupParticipants(context.Background(), groupJid, jids, change)
+	if err != nil {
+		return nil, err
+	}
+
+	groupName := ""
+	result := make([]interface{}, 0, len(updated))
+	for _, p := range updated {
+		outcome := participantOutcome(p)
+		if p.AddRequest != nil {
+			if groupName == "" {
+				if info, err := client.GetGroupInfo(context.Background(), groupJid); err == nil {
+					groupName = info.Name
+				}
+			}
+			if err := sendGroupInvite(client, groupJid, groupName, p); err != nil {
+				outcome["inviteError"] = err.Error()
+			} else {
+				outcome["status"] = "invited"
+			}
+		}
+		result = append(result, outcome)
+	}
+	return result, nil
+}
+
+// ── Membership requests ──
+
+func GetJoinRequests(userId string, groupId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := client.GetGroupRequestParticipants(context.Background(), jid)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, 0, len(requests))
+	for _, r := range requests {
+		result = append(result, map[string]interface{}{
+			"number":      r.JID.User,
+			"jid":         r.JID.String(),
+			"requestedAt": r.RequestedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return result, nil
+}
+
+// ReviewJoinRequests approves or rejects pending membership requests.
+func ReviewJoinRequests(userId string, groupId string, participants []string, approve bool) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(participants) == 0 {
+		return nil, fmt.Errorf("participants are required")
+	}
+
+	jids, err := ParseUserJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+
+	action := whatsmeow.ParticipantChangeReject
+	if approve {
+		action = whatsmeow.ParticipantChangeApprove
+	}
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := client.UpdateGroupRequestParticipants(context.Background(), jid, jids, action)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, 0, len(updated))
+	for _, p := range updated {
+		result = append(result, participantOutcome(p))
+	}
+	return result, nil
+}
+
+// ── Group details and invite links ──
+
+func groupInfoView(userId string, client *whatsmeow.Client, info *types.GroupInfo) map[string]interface{} {
+	participants := make([]interface{}, 0, len(info.Participants))
+	for _, p := range info.Participants {
+		name := p.DisplayName
+		if n := resolveContactName(userId, client, p.JID); n != "" {
+			name = n
+		}
+		participants = append(participants, map[string]interface{}{
+			"number":       participantNumber(p),
+			"jid":          p.JID.String(),
+			"name":         name,
+			"isAdmin":      p.IsAdmin || p.IsSuperAdmin,
+			"isSuperAdmin": p.IsSuperAdmin,
+		})
+	}
+
+	owner := info.OwnerJID
+	if !info.OwnerPN.IsEmpty() {
+		owner = info.OwnerPN
+	}
+	count := info.ParticipantCount
+	if count == 0 {
+		count = len(info.Participants)
+	}
+
+	view := map[string]interface{}{
+		"id":               info.JID.User,
+		"jid":              info.JID.String(),
+		"name":             info.Name,
+		"description":      info.Topic,
+		"owner":            owner.User,
+		"createdAt":        info.GroupCreated.UTC().Format(time.RFC3339),
+		"participantCount": count,
+		"participants":     participants,
+		"settings": map[string]interface{}{
+			"announce":         info.IsAnnounce,
+			"locked":           info.IsLocked,
+			"approvalRequired": info.IsJoinApprovalRequired,
+			"ephemeral":        info.IsEphemeral,
+		},
+		"isCommunity": info.IsParent,
+	}
+	if info.IsEphemeral {
+		view["settings"].(map[string]interface{})["ephemeralTimer"] = info.DisappearingTimer
+	}
+	return view
+}
+
+func GetGroupDetails(userId string, groupId string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.GetGroupInfo(context.Background(), jid)
+	if err != nil {
+		return nil, err
+	}
+	metadataFor(userId).setGroupName(info.JID, info.Name)
+	return groupInfoView(userId, client, info), nil
+}
+
+// GetInviteLink returns the group's invite link, revoking the old one and
+// generating a new one first if reset is set.
+func GetInviteLink(userId string, groupId string, reset bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	jid, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	link, err := client.GetGroupInviteLink(context.Background(), jid, reset)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"groupId":    jid.User,
+		"inviteLink": link,
+		"inviteCode": strings.TrimPrefix(link, whatsmeow.InviteLinkPrefix),
+	}, nil
+}
+
+// PreviewInvite looks up a group from an invite link or code without
+// joining it.
+func PreviewInvite(userId string, invite string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.GetGroupInfoFromLink(context.Background(), strings.TrimSpace(invite))
+	if err != nil {
+		return nil, err
+	}
+	return groupInfoView(userId, client, info), nil
+}
+
+// ── Communities ──
+
+func communityView(info *types.GroupInfo, subgroups []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          info.JID.User,
+		"jid":         info.JID.String(),
+		"name":        info.Name,
+		"description": info.Topic,
+		"createdAt":   info.GroupCreated.UTC().Format(time.RFC3339),
+		"groups":      subgroups,
+	}
+}
+
+func subgroupView(jid types.JID, name string, announcement bool) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             jid.User,
+		"jid":            jid.String(),
+		"name":           name,
+		"isAnnouncement": announcement,
+	}
+}
+
+// GetCommunities lists the communities the account belongs to, each with
+// the subgroups the account has joined.
+func GetCommunities(userId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := client.GetJoinedGroups(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	subgroups := make(map[string][]interface{})
+	for _, g := range groups {
+		if !g.LinkedParentJID.IsEmpty() {
+			parent := g.LinkedParentJID.User
+			subgroups[parent] = append(subgroups[parent], subgroupView(g.JID, g.Name, g.IsDefaultSubGroup))
+		}
+	}
+
+	result := make([]interface{}, 0)
+	for _, g := range groups {
+		if g.IsParent {
+			linked := subgroups[g.JID.User]
+			if linked == nil {
+				linked = make([]interface{}, 0)
+			}
+			result = append(result, communityView(g, linked))
+		}
+	}
+	return result, nil
+}
+
+// GetCommunityGroups lists every subgroup of a community, including ones
+// the account hasn't joined.
+func GetCommunityGroups(userId string, communityId string) ([]interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	community, err := ParseGroupJID(communityId)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := client.GetSubGroups(context.Background(), community)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(subs))
+	for _, s := range subs {
+		result = append(result, subgroupView(s.JID, s.Name, s.IsDefaultSubGroup))
+	}
+	return result, nil
+}
+
+// CreateCommunity creates a community. WhatsApp creates its announcement
+// group automatically.
+func CreateCommunity(userId string, name string, description string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.CreateGroup(context.Background(), whatsmeow.ReqCreateGroup{
+		Name:        name,
+		GroupParent: types.GroupParent{IsParent: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	metadataFor(userId).setGroupName(info.JID, info.Name)
+
+	if description != "" {
+		if err := client.SetGroupTopic(context.Background(), info.JID, "", "", description); err != nil {
+			return nil, fmt.Errorf("community created but setting its description failed: %v", err)
+		}
+		info.Topic = description
+	}
+	result := communityView(info, make([]interface{}, 0))
+	emitEvent(userId, "community.created", result)
+	return result, nil
+}
+
+// LinkCommunityGroup adds an existing group to a community, or removes it
+// when link is false.
+func LinkCommunityGroup(userId string, communityId string, groupId string, link bool) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := ParseGroupJID(communityId)
+	if err != nil {
+		return nil, err
+	}
+	child, err := ParseGroupJID(groupId)
+	if err != nil {
+		return nil, err
+	}
+	if link {
+		err = client.LinkGroup(context.Background(), parent, child)
+	} else {
+		err = client.UnlinkGroup(context.Background(), parent, child)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"success": true, "communityId": parent.User, "groupId": child.User, "linked": link}, nil
+}
+
+// SendCommunityAnnouncement posts to the community's announcement group,
+// which only community admins can send to.
+func SendCommunityAnnouncement(userId string, communityId string, message string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	community, err := ParseGroupJID(communityId)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := client.GetSubGroups(context.Background(), community)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		if s.IsDefaultSubGroup {
+			return SendGroupMessage(userId, s.JID.User, message, SendOptions{})
+		}
+	}
+	return nil, fmt.Errorf("community has no announcement group")
+}
@@ -0,0 +1,54 @@
+package whatsapp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestGroupPhotoJPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 800))
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 1000; x++ {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	out, err := groupPhotoJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("output is not a JPEG: %v %v", format, err)
+	}
+	if cfg.Width != groupPhotoSize || cfg.Height != groupPhotoSize {
+		t.Errorf("output is %dx%d, want a %d square", cfg.Width, cfg.Height, groupPhotoSize)
+	}
+
+	if _, err := groupPhotoJPEG([]byte("not an image")); err == nil {
+		t.Error("accepted data that isn't an image")
+	}
+}
+
+// gifHeader returns a GIF that claims the given dimensions in a few bytes,
+// like a decompression bomb would.
+func gifHeader(width, height uint16) []byte {
+	header := []byte("GIF89a")
+	header = binary.LittleEndian.AppendUint16(header, width)
+	header = binary.LittleEndian.AppendUint16(header, height)
+	return append(header, 0, 0, 0, ';')
+}
+
+func TestGroupPhotoJPEGRejectsHugeDimensions(t *testing.T) {
+	_, err := groupPhotoJPEG(gifHeader(60000, 60000))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("err = %v, want a too large error", err)
+	}
+}
@@ -0,0 +1,401 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+// ── Link previews ──
+//
+// Text sent through the API gets a preview card for its first link, as the
+// official apps do. Previews are best effort: when the page can't be read in
+// time the message goes out without one.
+
+const (
+	linkPreviewTimeout  = 5 * time.Second
+	linkPreviewMaxPage  = 512 << 10
+	linkPreviewMaxImage = 5 << 20
+	// linkPreviewThumbSize is the longest side of inline thumbnails, in
+	// pixels. WhatsApp embeds them in the message, so they're kept small.
+	linkPreviewThumbSize = 300
+	// linkPreviewMaxSide bounds images by their header before decoding, as
+	// a small file can claim dimensions that would exhaust memory.
+	linkPreviewMaxSide = 4096
+)
+
+// LinkPreview is the card shown under a message's first link.
+type LinkPreview struct {
+	Title       string
+	Description string
+	// Thumbnail is a small JPEG, or nil for a card without an image.
+	Thumbnail []byte
+}
+
+// PreviewFetcher looks up the preview for a URL. A nil preview with a nil
+// error means the page has none.
+type PreviewFetcher interface {
+	FetchPreview(ctx context.Context, url string) (*LinkPreview, error)
+}
+
+// StaticPreviewFetcher serves previews from a fixed map keyed by URL
+// without touching the network, for offline use and tests. Other URLs get
+// no preview.
+type StaticPreviewFetcher map[string]LinkPreview
+
+func (f StaticPreviewFetcher) FetchPreview(ctx context.Context, url string) (*LinkPreview, error) {
+	preview, ok := f[url]
+	if !ok {
+		return nil, nil
+	}
+	return &preview, nil
+}
+
+var (
+	previewFetcher      PreviewFetcher = HTTPPreviewFetcher{}
+	previewFetcherMutex                = &sync.RWMutex{}
+)
+
+// SetPreviewFetcher replaces the fetcher used for outgoing text. Nil turns
+// link previews off.
+func SetPreviewFetcher(f PreviewFetcher) {
+	previewFetcherMutex.Lock()
+	defer previewFetcherMutex.Unlock()
+	previewFetcher = f
+}
+
+func getPreviewFetcher() PreviewFetcher {
+	previewFetcherMutex.RLock()
+	defer previewFetcherMutex.RUnlock()
+	return previewFetcher
+}
+
+// linkPattern matches web links with a scheme or a leading www.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// firstLink returns the first link in text as written, and the URL to
+// fetch for it.
+func firstLink(text string) (matched string, target string) {
+	matched = strings.TrimRight(linkPattern.FindString(text), ".,;:!?'\")]}")
+	if matched == "" {
+		return "", ""
+	}
+	target = matched
+	if !strings.Contains(strings.ToLower(target), "://") {
+		target = "https://" + target
+	}
+	if u, err := url.Parse(target); err != nil || u.Host == "" {
+		return "", ""
+	}
+	return matched, target
+}
+
+// addLinkPreview attaches a preview of the first link in a text message.
+// It leaves the message untouched if there is no link or no preview.
+func addLinkPreview(msg *waProto.Message) {
+	text := msg.GetConversation()
+	if text == "" {
+		text = msg.GetExtendedTextMessage().GetText()
+	}
+	matched, target := firstLink(text)
+	fetcher := getPreviewFetcher()
+	if matched == "" || fetcher == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), linkPreviewTimeout)
+	defer cancel()
+	preview, err := fetcher.FetchPreview(ctx, target)
+	if err != nil {
+		fmt.Printf("⚠️ Link preview for %s failed: %v\n", target, err)
+		return
+	}
+	if preview == nil || preview.Title == "" {
+		return
+	}
+
+	if msg.ExtendedTextMessage == nil {
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: proto.String(text)}
+		msg.Conversation = nil
+	}
+	ext := msg.ExtendedTextMessage
+	ext.MatchedText = proto.String(matched)
+	ext.Title = proto.String(preview.Title)
+	if preview.Description != "" {
+		ext.Description = proto.String(preview.Description)
+	}
+	previewType := waProto.ExtendedTextMessage_NONE
+	if len(preview.Thumbnail) > 0 {
+		ext.JPEGThumbnail = preview.Thumbnail
+		previewType = waProto.ExtendedTextMessage_IMAGE
+	}
+	ext.PreviewType = &previewType
+}
+
+// ── Fetching previews over HTTP ──
+
+// HTTPPreviewFetcher reads a page's Open Graph tags, falling back to its
+// <title> and meta description. By default it only connects to public
+// addresses, so previews can't be used to reach services on the server's
+// own network.
+type HTTPPreviewFetcher struct {
+	// Client overrides the guarded default client, for tests.
+	Client *http.Client
+}
+
+var errPrivateAddress = errors.New("refusing to fetch a non-public address")
+
+// previewDeniedRanges are the addresses previews never connect to: private,
+// shared, loopback, link-local, multicast and reserved ranges, and the IPv6
+// prefixes that embed IPv4 addresses. IPv4-mapped IPv6 addresses are checked
+// as IPv4.
+var previewDeniedRanges = func() []netip.Prefix {
+	cidrs := []string{
+		"0.0.0.0/8",       // "this" network
+		"10.0.0.0/8",      // private
+		"100.64.0.0/10",   // carrier-grade NAT
+		"127.0.0.0/8",     // loopback
+		"169.254.0.0/16",  // link-local, including cloud metadata
+		"172.16.0.0/12",   // private
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"192.88.99.0/24",  // 6to4 relay
+		"192.168.0.0/16",  // private
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"224.0.0.0/4",     // multicast
+		"240.0.0.0/4",     // reserved, including broadcast
+		"::/128",          // unspecified
+		"::1/128",         // loopback
+		"64:ff9b::/96",    // NAT64
+		"64:ff9b:1::/48",  // local NAT64
+		"100::/64",        // discard
+		"2001::/32",       // Teredo
+		"2001:db8::/32",   // documentation
+		"2002::/16",       // 6to4
+		"fc00::/7",        // unique local
+		"fe80::/10",       // link-local
+		"ff00::/8",        // multicast
+	}
+	prefixes := make([]netip.Prefix, len(cidrs))
+	for i, cidr := range cidrs {
+		prefixes[i] = netip.MustParsePrefix(cidr)
+	}
+	return prefixes
+}()
+
+// previewAddressAllowed reports whether previews may connect to ip.
+func previewAddressAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range previewDeniedRanges {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var previewHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: linkPreviewTimeout,
+			// Checked on the resolved address of every connection, so
+			// neither DNS nor redirects can lead to a denied one
+			Control: func(network, address string, c syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !previewAddressAllowed(addrPort.Addr()) {
+					return fmt.Errorf("%w: %s", errPrivateAddress, addrPort.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: linkPreviewTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+func previewGet(ctx context.Context, client *http.Client, target string, limit int64) ([]byte, string, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// Many sites only serve preview tags to known crawlers
+	req.Header.Set("User-Agent", "WhatsApp/2.0")
+	req.Header.Set("Accept-Language", "en")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return body, mediaType, resp.Request.URL, nil
+}
+
+func (f HTTPPreviewFetcher) FetchPreview(ctx context.Context, target string) (*LinkPreview, error) {
+	client := f.Client
+	if client == nil {
+		client = previewHTTPClient
+	}
+	page, mediaType, pageURL, err := previewGet(ctx, client, target, linkPreviewMaxPage)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, nil
+	}
+
+	tags := pagePreviewTags(page)
+	preview := &LinkPreview{
+		Title:       firstNonEmpty(tags["og:title"], tags["twitter:title"], tags["title"]),
+		Description: firstNonEmpty(tags["og:description"], tags["twitter:description"], tags["description"]),
+	}
+	if preview.Title == "" {
+		return nil, nil
+	}
+
+	// A missing or broken image still leaves a usable text card
+	if img := firstNonEmpty(tags["og:image"], tags["twitter:image"]); img != "" {
+		if imgURL, err := pageURL.Parse(img); err == nil {
+			if data, _, _, err := previewGet(ctx, client, imgURL.String(), linkPreviewMaxImage); err == nil {
+				preview.Thumbnail, _ = previewThumbnail(data)
+			}
+		}
+	}
+	return preview, nil
+}
+
+// pagePreviewTags collects the <title> and the title, description and image
+// meta tags from a page's head.
+func pagePreviewTags(page []byte) map[string]string {
+	tags := make(map[string]string)
+	z := html.NewTokenizer(bytes.NewReader(page))
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return tags
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return tags
+			case "title":
+				inTitle = true
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = strings.TrimSpace(string(v))
+					}
+				}
+				if _, seen := tags[key]; key != "" && content != "" && !seen {
+					tags[key] = content
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head":
+				return tags
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if _, seen := tags["title"]; inTitle && !seen {
+				tags["title"] = strings.TrimSpace(string(z.Text()))
+			}
+		}
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// previewThumbnail scales an image down to an inline JPEG thumbnail,
+// keeping its aspect ratio.
+func previewThumbnail(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width > linkPreviewMaxSide || cfg.Height > linkPreviewMaxSide {
+		return nil, fmt.Errorf("image is too large (%dx%d)", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	w, h := b.Dx(), b.Dy()
+	if w > linkPreviewThumbSize || h > linkPreviewThumbSize {
+		if w >= h {
+			w, h = linkPreviewThumbSize, max(1, h*linkPreviewThumbSize/w)
+		} else {
+			w, h = max(1, w*linkPreviewThumbSize/h), linkPreviewThumbSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,167 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPreviewAddressAllowed(t *testing.T) {
+	for _, addr := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "0.0.0.0",
+		"100.64.0.1", "100.127.255.254", // carrier-grade NAT
+		"169.254.169.254", "198.18.0.1", "224.0.0.1", "255.255.255.255",
+		"::", "::1", "fe80::1", "fd00::1", "ff02::1", "2001:db8::1",
+		"::ffff:127.0.0.1", "::ffff:100.64.0.1", "64:ff9b::a00:1", "2002:a00:1::1",
+	} {
+		if previewAddressAllowed(netip.MustParseAddr(addr)) {
+			t.Errorf("%s is allowed", addr)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "1.1.1.1", "100.128.0.1", "2606:4700:4700::1111", "::ffff:8.8.8.8"} {
+		if !previewAddressAllowed(netip.MustParseAddr(addr)) {
+			t.Errorf("%s is refused", addr)
+		}
+	}
+}
+
+func TestHTTPPreviewFetcherRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the guarded fetcher reached a loopback server")
+	}))
+	defer server.Close()
+
+	for _, target := range []string{server.URL, "http://100.64.0.1/", "http://[::1]:9/"} {
+		_, err := HTTPPreviewFetcher{}.FetchPreview(context.Background(), target)
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("FetchPreview(%s) = %v, want errPrivateAddress", target, err)
+		}
+	}
+}
+
+func previewTestServer(t *testing.T, pages map[string]string, images map[string][]byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+			return
+		}
+		if img, ok := images[r.URL.Path]; ok {
+			w.Write(img)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPPreviewFetcherReadsTags(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 600, 400)))
+
+	server := previewTestServer(t, map[string]string{
+		"/og": `<html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="Open Graph title">
+			<meta property="og:description" content=" The description ">
+			<meta property="og:image" content="/img.png">
+			</head><body><meta property="og:title" content="Ignored"></body></html>`,
+		"/plain": `<html><head><title> Plain page </title><meta name="description" content="Meta description"></head></html>`,
+		"/bomb":  `<html><head><meta property="og:title" content="Bomb"><meta property="og:image" content="/bomb.gif"></head></html>`,
+		"/none":  `<html><head></head><body>No title here</body></html>`,
+	}, map[string][]byte{"/img.png": img.Bytes(), "/bomb.gif": gifHeader(60000, 60000)})
+	fetcher := HTTPPreviewFetcher{Client: server.Client()}
+
+	preview, err := fetcher.FetchPreview(context.Background(), server.URL+"/og")
+	if err != nil || preview == nil {
+		t.Fatalf("FetchPreview = %v, %v", preview, err)
+	}
+	if preview.Title != "Open Graph title" || preview.Description != "The description" {
+		t.Errorf("preview = %q / %q", preview.Title, preview.Description)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(preview.Thumbnail))
+	if err != nil || format != "jpeg" || cfg.Width != 300 || cfg.Height != 200 {
+		t.Errorf("thumbnail is %s %dx%d (%v), want a 300x200 JPEG", format, cfg.Width, cfg.Height, err)
+	}
+
+	preview, err = fetcher.FetchPreview(context.Background(), server.URL+"/plain")
+	if err != nil || preview == nil || preview.Title != "Plain page" || preview.Description != "Meta description" || preview.Thumbnail != nil {
+		t.Errorf("fallback preview = %+v, %v", preview, err)
+	}
+
+	// An image too large to decode safely still leaves a text card
+	preview, err = fetcher.FetchPreview(context.Background(), server.URL+"/bomb")
+	if err != nil || preview == nil || preview.Title != "Bomb" || preview.Thumbnail != nil {
+		t.Errorf("oversized image preview = %+v, %v", preview, err)
+	}
+
+	for _, path := range []string{"/none", "/img.png"} {
+		if preview, err := fetcher.FetchPreview(context.Background(), server.URL+path); err != nil || preview != nil {
+			t.Errorf("FetchPreview(%s) = %+v, %v; want no preview", path, preview, err)
+		}
+	}
+	if _, err := fetcher.FetchPreview(context.Background(), server.URL+"/missing"); err == nil {
+		t.Error("a 404 page didn't return an error")
+	}
+}
+
+func TestPreviewThumbnailRejectsHugeDimensions(t *testing.T) {
+	if _, err := previewThumbnail(gifHeader(5000, 10)); err == nil {
+		t.Error("decoded an image wider than the limit")
+	}
+}
+
+func TestAddLinkPreview(t *testing.T) {
+	SetPreviewFetcher(StaticPreviewFetcher{
+		"https://www.example.com/page": {Title: "Example", Description: "An example page", Thumbnail: []byte{0xff, 0xd8}},
+		"https://example.org/":         {Title: "Text only"},
+	})
+	defer SetPreviewFetcher(HTTPPreviewFetcher{})
+
+	msg := &waProto.Message{Conversation: proto.String("Have a look at www.example.com/page.")}
+	addLinkPreview(msg)
+	ext := msg.GetExtendedTextMessage()
+	if msg.Conversation != nil || ext == nil {
+		t.Fatalf("message wasn't converted to extended text: %v", msg)
+	}
+	if ext.GetText() != "Have a look at www.example.com/page." || ext.GetMatchedText() != "www.example.com/page" {
+		t.Errorf("text %q, matched %q", ext.GetText(), ext.GetMatchedText())
+	}
+	if ext.GetTitle() != "Example" || ext.GetDescription() != "An example page" || len(ext.GetJPEGThumbnail()) != 2 {
+		t.Errorf("card = %q / %q / %d bytes", ext.GetTitle(), ext.GetDescription(), len(ext.GetJPEGThumbnail()))
+	}
+	if ext.GetPreviewType() != waProto.ExtendedTextMessage_IMAGE {
+		t.Errorf("preview type = %v", ext.GetPreviewType())
+	}
+
+	msg = &waProto.Message{Conversation: proto.String("(https://example.org/)")}
+	addLinkPreview(msg)
+	if ext := msg.GetExtendedTextMessage(); ext.GetTitle() != "Text only" || ext.GetPreviewType() != waProto.ExtendedTextMessage_NONE {
+		t.Errorf("text-only card = %v", ext)
+	}
+
+	for _, text := range []string{"no links here", "https://unknown.example.net"} {
+		msg := &waProto.Message{Conversation: proto.String(text)}
+		addLinkPreview(msg)
+		if msg.GetConversation() != text || msg.ExtendedTextMessage != nil {
+			t.Errorf("%q was changed without a preview: %v", text, msg)
+		}
+	}
+
+	SetPreviewFetcher(nil)
+	msg = &waProto.Message{Conversation: proto.String("www.example.com/page")}
+	addLinkPreview(msg)
+	if msg.ExtendedTextMessage != nil {
+		t.Error("previews were added while disabled")
+	}
+}
@@ -0,0 +1,131 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// ErrUnsupportedMedia is returned for uploads that aren't an image or video.
+var ErrUnsupportedMedia = errors.New("unsupported media type")
+
+// mediaUpload is an uploaded attachment wrapped in a ready-to-send message.
+type mediaUpload struct {
+	Message  *waProto.Message
+	Kind     string
+	Mimetype string
+	// Handle must be passed as SendRequestExtra.MediaHandle when sending to
+	// a channel.
+	Handle string
+}
+
+// uploadMedia uploads an image or video and builds the message carrying it.
+// Channel media is uploaded unencrypted, as WhatsApp requires. The mimetype
+// is sniffed from the data when not given.
+func uploadMedia(client *whatsmeow.Client, data []byte, mimetype string, caption string, newsletter bool) (*mediaUpload, error) {
+	if mimetype == "" || mimetype == "application/octet-stream" {
+		mimetype = http.DetectContentType(data)
+	}
+
+	var kind string
+	var mediaType whatsmeow.MediaType
+	switch {
+	case strings.HasPrefix(mimetype, "image/"):
+		kind, mediaType = "image", whatsmeow.MediaImage
+	case strings.HasPrefix(mimetype, "video/"):
+		kind, mediaType = "video", whatsmeow.MediaVideo
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMedia, mimetype)
+	}
+
+	var up whatsmeow.UploadResponse
+	var err error
+	if newsletter {
+		up, err = client.UploadNewsletter(context.Background(), data, mediaType)
+	} else {
+		up, err = client.Upload(context.Background(), data, mediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &waProto.Message{}
+	if kind == "image" {
+		msg.ImageMessage = &waProto.ImageMessage{
+			Caption:       &caption,
+			Mimetype:      &mimetype,
+			URL:           &up.URL,
+			DirectPath:    &up.DirectPath,
+			MediaKey:      up.MediaKey,
+			FileEncSHA256: up.FileEncSHA256,
+			FileSHA256:    up.FileSHA256,
+			FileLength:    &up.FileLength,
+		}
+	} else {
+		msg.VideoMessage = &waProto.VideoMessage{
+			Caption:       &caption,
+			Mimetype:      &mimetype,
+			URL:           &up.URL,
+			DirectPath:    &up.DirectPath,
+			MediaKey:      up.MediaKey,
+			FileEncSHA256: up.FileEncSHA256,
+			FileSHA256:    up.FileSHA256,
+			FileLength:    &up.FileLength,
+		}
+	}
+	return &mediaUpload{Message: msg, Kind: kind, Mimetype: mimetype, Handle: up.Handle}, nil
+}
+
+// SendMedia sends an image or video to a user or group. View-once media
+// can be opened only once by the recipient.
+func SendMedia(userId string, chat string, data []byte, mimetype string, caption string, opts SendOptions) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	if jid, err = verifyRecipient(client, jid); err != nil {
+		return nil, err
+	}
+
+	media, err := uploadMedia(client, data, mimetype, caption, false)
+	if err != nil {
+		return nil, err
+	}
+	msg := media.Message
+	expiration := expirationFor(userId, jid, opts.Ephemeral)
+	applyExpiration(msg, expiration)
+	if opts.ViewOnce {
+		if msg.ImageMessage != nil {
+			msg.ImageMessage.ViewOnce = &opts.ViewOnce
+		} else {
+			msg.VideoMessage.ViewOnce = &opts.ViewOnce
+		}
+		msg = &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}
+	}
+
+	if opts.Typing {
+		simulateTyping(client, jid, caption)
+	}
+	resp, err := client.SendMessage(context.Background(), jid, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	messageData := sentMessageData(userId, client, jid, msg, resp, expiration)
+	storage.PushToUserMessage(userId, messageData)
+	storage.IncrementStatUser(userId, "messagesSent")
+	keepContent(userId, resp.ID, msg)
+
+	return messageData, nil
+}
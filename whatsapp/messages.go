@@ -0,0 +1,398 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ── Message content ──
+
+// ErrMessageNotFound is returned for message IDs that aren't in the log.
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrNotForwardable is returned for messages that can't be forwarded, such
+// as view-once media, reactions and messages logged without their content.
+var ErrNotForwardable = errors.New("message can't be forwarded")
+
+// ErrInvalidTimer is returned for disappearing timers WhatsApp doesn't
+// offer.
+var ErrInvalidTimer = errors.New("invalid disappearing timer")
+
+// disappearingTimers are the timers WhatsApp offers, in seconds.
+var disappearingTimers = map[uint32]time.Duration{
+	0:       0,
+	86400:   24 * time.Hour,
+	604800:  7 * 24 * time.Hour,
+	7776000: 90 * 24 * time.Hour,
+}
+
+// unwrapMessage strips the ephemeral and view-once wrappers. whatsmeow
+// already does this for live events; history and stored messages can still
+// carry them.
+func unwrapMessage(msg *waProto.Message) (inner *waProto.Message, ephemeral bool, viewOnce bool) {
+	for msg != nil {
+		switch {
+		case msg.GetEphemeralMessage().GetMessage() != nil:
+			msg, ephemeral = msg.GetEphemeralMessage().GetMessage(), true
+		case msg.GetViewOnceMessage().GetMessage() != nil:
+			msg, viewOnce = msg.GetViewOnceMessage().GetMessage(), true
+		case msg.GetViewOnceMessageV2().GetMessage() != nil:
+			msg, viewOnce = msg.GetViewOnceMessageV2().GetMessage(), true
+		case msg.GetViewOnceMessageV2Extension().GetMessage() != nil:
+			msg, viewOnce = msg.GetViewOnceMessageV2Extension().GetMessage(), true
+		case msg.GetDocumentWithCaptionMessage().GetMessage() != nil:
+			msg = msg.GetDocumentWithCaptionMessage().GetMessage()
+		default:
+			return msg, ephemeral, viewOnce
+		}
+	}
+	return msg, ephemeral, viewOnce
+}
+
+func withCaption(placeholder string, caption string) string {
+	if caption == "" {
+		return placeholder
+	}
+	return caption
+}
+
+// messageContent returns the kind of a message and the text to log for it:
+// the text itself, a media caption, or a placeholder such as "[Image]".
+func messageContent(msg *waProto.Message) (kind string, body string) {
+	msg, _, _ = unwrapMessage(msg)
+	switch {
+	case msg.GetConversation() != "":
+		return "text", msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return "text", msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return "image", withCaption("[Image]", msg.GetImageMessage().GetCaption())
+	case msg.GetVideoMessage() != nil:
+		if msg.GetVideoMessage().GetGifPlayback() {
+			return "gif", withCaption("[GIF]", msg.GetVideoMessage().GetCaption())
+		}
+		return "video", withCaption("[Video]", msg.GetVideoMessage().GetCaption())
+	case msg.GetPtvMessage() != nil:
+		return "video_note", "[Video message]"
+	case msg.GetAudioMessage() != nil:
+		if msg.GetAudioMessage().GetPTT() {
+			return "voice", "[Voice message]"
+		}
+		return "audio", "[Audio]"
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		return "document", withCaption(withCaption("[Document]", doc.GetFileName()), doc.GetCaption())
+	case msg.GetStickerMessage() != nil:
+		return "sticker", "[Sticker]"
+	case msg.GetLocationMessage() != nil:
+		loc := msg.GetLocationMessage()
+		return "location", withCaption("[Location]", withCaption(loc.GetAddress(), loc.GetName()))
+	case msg.GetLiveLocationMessage() != nil:
+		return "live_location", withCaption("[Live location]", msg.GetLiveLocationMessage().GetCaption())
+	case msg.GetContactMessage() != nil:
+		return "contact", "[Contact] " + msg.GetContactMessage().GetDisplayName()
+	case msg.GetContactsArrayMessage() != nil:
+		return "contacts", fmt.Sprintf("[%d contacts]", len(msg.GetContactsArrayMessage().GetContacts()))
+	case msg.GetPollCreationMessage() != nil, msg.GetPollCreationMessageV2() != nil, msg.GetPollCreationMessageV3() != nil:
+		poll := msg.GetPollCreationMessage()
+		if poll == nil {
+			poll = msg.GetPollCreationMessageV2()
+		}
+		if poll == nil {
+			poll = msg.GetPollCreationMessageV3()
+		}
+		return "poll", "[Poll] " + poll.GetName()
+	case msg.GetReactionMessage() != nil:
+		return "reaction", msg.GetReactionMessage().GetText()
+	}
+	return "other", "Media/Other Message"
+}
+
+// messageBody returns the text of a message for the log.
+func messageBody(msg *waProto.Message) string {
+	_, body := messageContent(msg)
+	return body
+}
+
+// onlyKeyDistribution reports whether msg carries nothing but group
+// encryption keys, as the first message to a group from a device can.
+func onlyKeyDistribution(msg *waProto.Message) bool {
+	if msg.GetSenderKeyDistributionMessage() == nil {
+		return false
+	}
+	rest := proto.Clone(msg).(*waProto.Message)
+	rest.SenderKeyDistributionMessage = nil
+	rest.MessageContextInfo = nil
+	return proto.Size(rest) == 0
+}
+
+// keepContent stores a logged message's content so it can be forwarded.
+func keepContent(userId string, id string, msg *waProto.Message) {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	storage.SaveMessageContent(userId, id, raw)
+}
+
+// sentMessageData builds the log entry for a message sent through the API.
+func sentMessageData(userId string, client *whatsmeow.Client, jid types.JID, msg *waProto.Message, resp whatsmeow.SendResponse, expiration uint32) map[string]interface{} {
+	isGroup := jid.Server == types.GroupServer
+	var groupName interface{}
+	contactName := "Group"
+	if isGroup {
+		groupName = resolveGroupName(userId, client, jid)
+	} else if contactName = resolveContactName(userId, client, jid); contactName == "" {
+		contactName = jid.User
+	}
+
+	messageType, body := messageContent(msg)
+	_, _, viewOnce := unwrapMessage(msg)
+	return map[string]interface{}{
+		"id":          resp.ID,
+		"chat":        jid.String(),
+		"from":        "me",
+		"to":          jid.String(),
+		"body":        body,
+		"messageType": messageType,
+		"isEphemeral": expiration > 0,
+		"isViewOnce":  viewOnce,
+		"timestamp":   resp.Timestamp.UTC().Format(time.RFC3339),
+		"type":        "sent",
+		"origin":      "api",
+		"contactName": contactName,
+		"isGroup":     isGroup,
+		"groupName":   groupName,
+	}
+}
+
+// contentField returns the populated sub-message of msg that has a
+// contextInfo field (the text, image, video… message), if any.
+func contentField(msg *waProto.Message) protoreflect.Message {
+	var found protoreflect.Message
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return true
+		}
+		if fd.Message().Fields().ByName("contextInfo") != nil {
+			found = v.Message()
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// contextInfoOf returns the ContextInfo of a message's content, or nil.
+func contextInfoOf(msg *waProto.Message) *waProto.ContextInfo {
+	content := contentField(msg)
+	if content == nil {
+		return nil
+	}
+	fd := content.Descriptor().Fields().ByName("contextInfo")
+	if !content.Has(fd) {
+		return nil
+	}
+	info, _ := content.Get(fd).Message().Interface().(*waProto.ContextInfo)
+	return info
+}
+
+// withContextInfo returns the ContextInfo of a message's content, creating
+// it if needed. Plain conversation messages are turned into extended text
+// messages since they have none.
+func withContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	if msg.Conversation != nil {
+		msg.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: msg.Conversation}
+		msg.Conversation = nil
+	}
+	content := contentField(msg)
+	if content == nil {
+		return nil
+	}
+	fd := content.Descriptor().Fields().ByName("contextInfo")
+	info, _ := content.Mutable(fd).Message().Interface().(*waProto.ContextInfo)
+	return info
+}
+
+// ── Disappearing messages ──
+
+// SetDisappearingTimer sets a chat's disappearing message timer, in
+// seconds: 0 (off), 86400 (24 hours), 604800 (7 days) or 7776000 (90 days).
+func SetDisappearingTimer(userId string, chat string, seconds uint32) (interface{}, error) {
+	timer, ok := disappearingTimers[seconds]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d (use 0, 86400, 604800 or 7776000)", ErrInvalidTimer, seconds)
+	}
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetDisappearingTimer(context.Background(), jid, timer, time.Now()); err != nil {
+		return nil, err
+	}
+	storage.UpdateChatState(userId, jid.String(), func(s *storage.ChatState) { s.DisappearingTimer = seconds })
+	return map[string]interface{}{"success": true, "chat": jid.String(), "disappearingTimer": seconds}, nil
+}
+
+// expirationFor returns the disappearing timer to send a message to chat
+// with: the override if given, otherwise the chat's current timer.
+func expirationFor(userId string, chat types.JID, override *uint32) uint32 {
+	if override != nil {
+		return *override
+	}
+	return storage.GetChatState(userId, chat.String()).DisappearingTimer
+}
+
+// applyExpiration marks msg as disappearing after the given number of
+// seconds. Zero leaves it untouched.
+func applyExpiration(msg *waProto.Message, seconds uint32) {
+	if seconds == 0 {
+		return
+	}
+	if info := withContextInfo(msg); info != nil {
+		info.Expiration = &seconds
+	}
+}
+
+// updateDisappearingTimers keeps each chat's timer current from timer
+// changes, disappearing messages and group info.
+func updateDisappearingTimers(userId string, evt interface{}) {
+	setTimer := func(chat types.JID, seconds uint32) {
+		storage.UpdateChatState(userId, chat.String(), func(s *storage.ChatState) { s.DisappearingTimer = seconds })
+	}
+	switch v := evt.(type) {
+	case *events.Message:
+		if pm := v.Message.GetProtocolMessage(); pm != nil && pm.GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING {
+			setTimer(v.Info.Chat, pm.GetEphemeralExpiration())
+			return
+		}
+		if v.IsEphemeral {
+			if info := contextInfoOf(v.Message); info != nil && info.GetExpiration() > 0 &&
+				storage.GetChatState(userId, v.Info.Chat.String()).DisappearingTimer != info.GetExpiration() {
+				setTimer(v.Info.Chat, info.GetExpiration())
+			}
+		}
+	case *events.GroupInfo:
+		if v.Ephemeral != nil {
+			setTimer(v.JID, v.Ephemeral.DisappearingTimer)
+		}
+	case *events.JoinedGroup:
+		if v.IsEphemeral {
+			setTimer(v.JID, v.DisappearingTimer)
+		}
+	}
+}
+
+// ── Forwarding ──
+
+// forwardableCopy decodes a stored message and prepares it to be sent
+// again: wrappers and replies are dropped and the forwarded flag is set.
+func forwardableCopy(raw []byte) (*waProto.Message, error) {
+	stored := &waProto.Message{}
+	if err := proto.Unmarshal(raw, stored); err != nil {
+		return nil, err
+	}
+	inner, _, viewOnce := unwrapMessage(stored)
+	if viewOnce {
+		return nil, fmt.Errorf("%w: view-once media", ErrNotForwardable)
+	}
+	switch kind, _ := messageContent(inner); kind {
+	case "poll", "reaction", "other":
+		return nil, fmt.Errorf("%w: %s messages", ErrNotForwardable, kind)
+	}
+
+	msg := proto.Clone(inner).(*waProto.Message)
+	msg.MessageContextInfo = nil
+	score := contextInfoOf(msg).GetForwardingScore()
+	info := withContextInfo(msg)
+	if info == nil {
+		return nil, ErrNotForwardable
+	}
+	proto.Reset(info)
+	forwarded := true
+	score++
+	info.IsForwarded = &forwarded
+	info.ForwardingScore = &score
+	return msg, nil
+}
+
+// ForwardMessage re-sends a logged message to each target chat, marked as
+// forwarded. Media is forwarded by reference, without downloading it
+// again. The result lists the outcome per target; one failing target
+// doesn't stop the others.
+func ForwardMessage(userId string, messageId string, targets []string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	logged, ok := storage.GetMessage(userId, messageId)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMessageNotFound, messageId)
+	}
+	if viewOnce, _ := logged["isViewOnce"].(bool); viewOnce {
+		return nil, fmt.Errorf("%w: view-once media", ErrNotForwardable)
+	}
+	raw, ok := storage.LoadMessageContent(userId, messageId)
+	if !ok {
+		return nil, fmt.Errorf("%w: its content wasn't kept", ErrNotForwardable)
+	}
+	content, err := forwardableCopy(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]map[string]interface{}, 0, len(targets))
+	sent := 0
+	for _, target := range targets {
+		result := map[string]interface{}{"to": target, "success": false}
+		results = append(results, result)
+
+		jid, err := ParseChatJID(target)
+		if err == nil {
+			jid, err = verifyRecipient(client, jid)
+		}
+		if err != nil {
+			result["error"] = err.Error()
+			continue
+		}
+
+		msg := proto.Clone(content).(*waProto.Message)
+		expiration := expirationFor(userId, jid, nil)
+		applyExpiration(msg, expiration)
+		resp, err := client.SendMessage(context.Background(), jid, msg)
+		if err != nil {
+			result["error"] = err.Error()
+			continue
+		}
+
+		messageData := sentMessageData(userId, client, jid, msg, resp, expiration)
+		messageData["isForwarded"] = true
+		storage.PushToUserMessage(userId, messageData)
+		storage.IncrementStatUser(userId, "messagesSent")
+		keepContent(userId, resp.ID, msg)
+
+		result["success"] = true
+		result["message"] = messageData
+		sent++
+	}
+	return map[string]interface{}{
+		"success":   sent == len(targets),
+		"forwarded": sent,
+		"results":   results,
+	}, nil
+}
@@ -0,0 +1,206 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ── Metadata cache ──
+//
+// Each instance keeps group subjects and contact names in memory so
+// messages and webhook payloads can carry real names without a query per
+// message. Contacts missing from the cache fall back to whatsmeow's own
+// contact store, which persists push names across restarts.
+
+type contactNames struct {
+	FullName     string
+	PushName     string
+	BusinessName string
+}
+
+func (n contactNames) best() string {
+	switch {
+	case n.FullName != "":
+		return n.FullName
+	case n.PushName != "":
+		return n.PushName
+	default:
+		return n.BusinessName
+	}
+}
+
+// groupLookupRetry is how long a group whose subject couldn't be fetched
+// waits before it's queried again.
+const groupLookupRetry = time.Minute
+
+type metadataCache struct {
+	mu       sync.RWMutex
+	groups   map[string]string
+	contacts map[string]contactNames
+	// lookups holds, per group, when its subject may next be queried. It
+	// covers lookups in flight as well as recent failures.
+	lookups map[string]time.Time
+}
+
+var (
+	metadata      = make(map[string]*metadataCache)
+	metadataMutex = &sync.Mutex{}
+)
+
+func metadataFor(userId string) *metadataCache {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	m, ok := metadata[userId]
+	if !ok {
+		m = &metadataCache{
+			groups:   make(map[string]string),
+			contacts: make(map[string]contactNames),
+			lookups:  make(map[string]time.Time),
+		}
+		metadata[userId] = m
+	}
+	return m
+}
+
+func dropMetadata(userId string) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	delete(metadata, userId)
+}
+
+func (m *metadataCache) setGroupName(group types.JID, name string) {
+	if name == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.groups[group.User] = name
+}
+
+func (m *metadataCache) groupName(group types.JID) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	name, ok := m.groups[group.User]
+	return name, ok
+}
+
+// claimGroupLookup reports whether the caller should query the group's
+// subject now, and if so holds off other queries for groupLookupRetry.
+func (m *metadataCache) claimGroupLookup(group types.JID, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Before(m.lookups[group.User]) {
+		return false
+	}
+	m.lookups[group.User] = now.Add(groupLookupRetry)
+	return true
+}
+
+// updateContact applies fn to the cached names of user.
+func (m *metadataCache) updateContact(user types.JID, fn func(n *contactNames)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.contacts[user.User]
+	fn(&n)
+	m.contacts[user.User] = n
+}
+
+func (m *metadataCache) contact(user types.JID) (contactNames, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.contacts[user.User]
+	return n, ok
+}
+
+// resolveGroupName returns the group's subject, or the group ID if it
+// isn't cached yet. It's called from event handlers, so a miss never waits
+// on the network: the subject is fetched in the background for later
+// messages, at most once per groupLookupRetry for groups that can't be
+// queried.
+func resolveGroupName(userId string, client *whatsmeow.Client, group types.JID) string {
+	m := metadataFor(userId)
+	if name, ok := m.groupName(group); ok {
+		return name
+	}
+	if client != nil && m.claimGroupLookup(group, time.Now()) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if info, err := client.GetGroupInfo(ctx, group); err == nil {
+				m.setGroupName(group, info.Name)
+			}
+		}()
+	}
+	return group.User
+}
+
+// resolveContactName returns the best known name for a user: their saved
+// contact name, push name or business name. It returns "" if none is known.
+func resolveContactName(userId string, client *whatsmeow.Client, user types.JID) string {
+	m := metadataFor(userId)
+	if n, ok := m.contact(user); ok && n.best() != "" {
+		return n.best()
+	}
+	if client == nil || client.Store.Contacts == nil {
+		return ""
+	}
+	contact, err := client.Store.Contacts.GetContact(context.Background(), user.ToNonAD())
+	if err != nil || !contact.Found {
+		return ""
+	}
+	names := contactNames{FullName: contact.FullName, PushName: contact.PushName, BusinessName: contact.BusinessName}
+	m.updateContact(user, func(n *contactNames) { *n = names })
+	return names.best()
+}
+
+// warmGroupNames loads the subjects of every joined group once connected.
+func warmGroupNames(userId string, client *whatsmeow.Client) {
+	groups, err := client.GetJoinedGroups(context.Background())
+	if err != nil {
+		return
+	}
+	m := metadataFor(userId)
+	for _, g := range groups {
+		m.setGroupName(g.JID, g.Name)
+	}
+}
+
+// updateMetadata keeps the cache current from contact, push name, history
+// sync and group change events.
+func updateMetadata(userId string, evt interface{}) {
+	m := metadataFor(userId)
+	switch v := evt.(type) {
+	case *events.Contact:
+		if v.Action != nil {
+			m.updateContact(v.JID, func(n *contactNames) { n.FullName = v.Action.GetFullName() })
+		}
+	case *events.PushName:
+		m.updateContact(v.JID, func(n *contactNames) { n.PushName = v.NewPushName })
+	case *events.BusinessName:
+		m.updateContact(v.JID, func(n *contactNames) { n.BusinessName = v.NewBusinessName })
+	case *events.GroupInfo:
+		if v.Name != nil {
+			m.setGroupName(v.JID, v.Name.Name)
+		}
+	case *events.JoinedGroup:
+		m.setGroupName(v.JID, v.Name)
+	case *events.HistorySync:
+		for _, conv := range v.Data.GetConversations() {
+			jid, err := types.ParseJID(conv.GetID())
+			if err == nil && jid.Server == types.GroupServer {
+				m.setGroupName(jid, conv.GetName())
+			}
+		}
+		for _, p := range v.Data.GetPushnames() {
+			jid, err := types.ParseJID(p.GetID())
+			if err == nil && p.GetPushname() != "" {
+				m.updateContact(jid, func(n *contactNames) { n.PushName = p.GetPushname() })
+			}
+		}
+	}
+}
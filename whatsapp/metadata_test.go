@@ -0,0 +1,43 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestResolveGroupNameFromCache(t *testing.T) {
+	userId := "metadata-test"
+	defer dropMetadata(userId)
+	group := types.NewJID("120363025246125486", types.GroupServer)
+
+	if got := resolveGroupName(userId, nil, group); got != group.User {
+		t.Errorf("uncached name = %q, want the group ID", got)
+	}
+	metadataFor(userId).setGroupName(group, "Team")
+	if got := resolveGroupName(userId, nil, group); got != "Team" {
+		t.Errorf("cached name = %q", got)
+	}
+}
+
+func TestClaimGroupLookup(t *testing.T) {
+	m := metadataFor("lookup-test")
+	defer dropMetadata("lookup-test")
+	group := types.NewJID("120363025246125486", types.GroupServer)
+	other := types.NewJID("120363000000000000", types.GroupServer)
+	now := time.Now()
+
+	if !m.claimGroupLookup(group, now) {
+		t.Fatal("the first lookup was refused")
+	}
+	if m.claimGroupLookup(group, now.Add(time.Second)) {
+		t.Error("a second lookup started while the first was pending")
+	}
+	if !m.claimGroupLookup(other, now) {
+		t.Error("a lookup for another group was refused")
+	}
+	if !m.claimGroupLookup(group, now.Add(groupLookupRetry)) {
+		t.Error("the group was never queried again")
+	}
+}
@@ -0,0 +1,127 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ── Presence & chat states ──
+
+// Typing simulation: roughly 40 words a minute, clamped so short replies
+// still show the indicator and long ones don't stall the request.
+const (
+	typingPerChar = 50 * time.Millisecond
+	typingMin     = 1 * time.Second
+	typingMax     = 8 * time.Second
+)
+
+// SetPresence marks the linked account online or offline. WhatsApp only
+// delivers typing indicators and read receipts while the account is online.
+func SetPresence(userId string, available bool) error {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return err
+	}
+	state := types.PresenceUnavailable
+	if available {
+		state = types.PresenceAvailable
+	}
+	return client.SendPresence(context.Background(), state)
+}
+
+// SendChatState shows "typing…" (composing), "recording audio…" (recording)
+// or clears the indicator (paused) in a chat.
+func SendChatState(userId string, chat string, state string) error {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return err
+	}
+	jid, err := ParseChatJID(chat)
+	if err != nil {
+		return err
+	}
+
+	var presence types.ChatPresence
+	media := types.ChatPresenceMediaText
+	switch state {
+	case "composing":
+		presence = types.ChatPresenceComposing
+	case "recording":
+		presence = types.ChatPresenceComposing
+		media = types.ChatPresenceMediaAudio
+	case "paused":
+		presence = types.ChatPresencePaused
+	default:
+		return fmt.Errorf("unknown chat state %q", state)
+	}
+	return client.SendChatPresence(context.Background(), jid, presence, media)
+}
+
+// SubscribePresence asks WhatsApp to send online/last-seen updates for a
+// contact. Updates arrive as presence.update events; the subscription lasts
+// until the connection drops.
+func SubscribePresence(userId string, number string) error {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return err
+	}
+	jid, err := ParseUserJID(number)
+	if err != nil {
+		return err
+	}
+	return client.SubscribePresence(context.Background(), jid)
+}
+
+func typingDuration(message string) time.Duration {
+	d := time.Duration(len([]rune(message))) * typingPerChar
+	if d < typingMin {
+		return typingMin
+	}
+	if d > typingMax {
+		return typingMax
+	}
+	return d
+}
+
+// simulateTyping shows the typing indicator in chat for a time based on the
+// message length. Failures are ignored; the message is sent regardless.
+func simulateTyping(client *whatsmeow.Client, chat types.JID, message string) {
+	ctx := context.Background()
+	if err := client.SendChatPresence(ctx, chat, types.ChatPresenceComposing, types.ChatPresenceMediaText); err != nil {
+		return
+	}
+	time.Sleep(typingDuration(message))
+	client.SendChatPresence(ctx, chat, types.ChatPresencePaused, types.ChatPresenceMediaText)
+}
+
+func handlePresence(userId string, evt *events.Presence) {
+	data := map[string]interface{}{
+		"from":      evt.From.ToNonAD().String(),
+		"number":    evt.From.User,
+		"available": !evt.Unavailable,
+		"lastSeen":  nil,
+	}
+	if !evt.LastSeen.IsZero() {
+		data["lastSeen"] = evt.LastSeen.UTC().Format(time.RFC3339)
+	}
+	emitEvent(userId, "presence.update", data)
+}
+
+func handleChatPresence(userId string, evt *events.ChatPresence) {
+	state := string(evt.State)
+	if evt.State == types.ChatPresenceComposing && evt.Media == types.ChatPresenceMediaAudio {
+		state = "recording"
+	}
+	data := map[string]interface{}{
+		"chat":    evt.Chat.String(),
+		"from":    evt.Sender.ToNonAD().String(),
+		"isGroup": evt.IsGroup,
+		"state":   state,
+	}
+	emitEvent(userId, "chat.presence", data)
+}
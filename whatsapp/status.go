@@ -0,0 +1,238 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"wa-server-go/storage"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ── Status updates ──
+//
+// Statuses are messages to status@broadcast. WhatsApp picks the recipients
+// from the account's status privacy setting (My contacts, My contacts
+// except…, Only share with…), which is managed on the phone; a single post
+// can't override it.
+
+var (
+	// ErrInvalidStatus is returned for status posts with missing or
+	// malformed content.
+	ErrInvalidStatus = errors.New("invalid status")
+	// ErrStatusAudience is returned when a post asks for an audience that
+	// doesn't match the account's status privacy setting.
+	ErrStatusAudience = errors.New("status audience mismatch")
+)
+
+// statusAudiences maps the API's audience names to WhatsApp's privacy types.
+var statusAudiences = map[string]types.StatusPrivacyType{
+	"contacts": types.StatusPrivacyTypeContacts,
+	"except":   types.StatusPrivacyTypeBlacklist,
+	"only":     types.StatusPrivacyTypeWhitelist,
+}
+
+func audienceName(t types.StatusPrivacyType) string {
+	for name, pt := range statusAudiences {
+		if pt == t {
+			return name
+		}
+	}
+	return string(t)
+}
+
+// StatusPost is a status update to publish. Text statuses use Text,
+// BackgroundColor and Font; media statuses use Media and Caption.
+type StatusPost struct {
+	Text            string
+	BackgroundColor string
+	Font            *int32
+	Media           []byte
+	Mimetype        string
+	Caption         string
+	// Audience, when set, must match the account's status privacy setting
+	// or the post is refused. It guards against posting to a wider audience
+	// than intended.
+	Audience string
+}
+
+// parseColor turns "#RRGGBB" or "#AARRGGBB" into the ARGB value WhatsApp
+// expects. Colors without alpha are opaque.
+func parseColor(color string) (uint32, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return 0, fmt.Errorf("%w: color %q, expected #RRGGBB", ErrInvalidStatus, color)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: color %q, expected #RRGGBB", ErrInvalidStatus, color)
+	}
+	if len(hex) == 6 {
+		v |= 0xFF000000
+	}
+	return uint32(v), nil
+}
+
+func formatColor(argb uint32) string {
+	return fmt.Sprintf("#%06X", argb&0xFFFFFF)
+}
+
+// GetStatusAudience reports who status posts are currently delivered to.
+func GetStatusAudience(userId string) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+	privacy, err := client.GetStatusPrivacy(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return audienceView(privacy[0]), nil
+}
+
+func audienceView(p types.StatusPrivacy) map[string]interface{} {
+	numbers := make([]string, 0, len(p.List))
+	for _, jid := range p.List {
+		numbers = append(numbers, jid.User)
+	}
+	return map[string]interface{}{"audience": audienceName(p.Type), "list": numbers}
+}
+
+// PostStatus publishes a text, image or video status update.
+func PostStatus(userId string, post StatusPost) (interface{}, error) {
+	client, err := connectedClient(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	privacy, err := client.GetStatusPrivacy(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if post.Audience != "" {
+		want, ok := statusAudiences[post.Audience]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown audience %q (use contacts, except or only)", ErrInvalidStatus, post.Audience)
+		}
+		if privacy[0].Type != want {
+			return nil, fmt.Errorf("%w: statuses currently go to %q; change status privacy on the phone first", ErrStatusAudience, audienceName(privacy[0].Type))
+		}
+	}
+
+	record := storage.StatusUpdate{FromMe: true, From: "me"}
+	msg := &waProto.Message{}
+	if len(post.Media) == 0 {
+		if post.Text == "" {
+			return nil, fmt.Errorf("%w: text or media is required", ErrInvalidStatus)
+		}
+		text := &waProto.ExtendedTextMessage{Text: &post.Text}
+		if post.BackgroundColor != "" {
+			argb, err := parseColor(post.BackgroundColor)
+			if err != nil {
+				return nil, err
+			}
+			text.BackgroundArgb = &argb
+			record.BackgroundColor = formatColor(argb)
+		}
+		if post.Font != nil {
+			if _, ok := waE2E.ExtendedTextMessage_FontType_name[*post.Font]; !ok {
+				return nil, fmt.Errorf("%w: unknown font %d", ErrInvalidStatus, *post.Font)
+			}
+			font := waProto.ExtendedTextMessage_FontType(*post.Font)
+			text.Font = &font
+			record.Font = post.Font
+		}
+		msg.ExtendedTextMessage = text
+		record.Type = "text"
+		record.Text = post.Text
+	} else {
+		media, err := uploadMedia(client, post.Media, post.Mimetype, post.Caption, false)
+		if err != nil {
+			return nil, err
+		}
+		msg = media.Message
+		record.Type = media.Kind
+		record.Caption = post.Caption
+		record.Mimetype = media.Mimetype
+	}
+
+	resp, err := client.SendMessage(context.Background(), types.StatusBroadcastJID, msg)
+	if err != nil {
+		return nil, err
+	}
+	record.ID = resp.ID
+	record.Timestamp = resp.Timestamp.UTC().Format(time.RFC3339)
+	storage.RecordStatus(userId, record)
+
+	return map[string]interface{}{
+		"success":  true,
+		"status":   record,
+		"audience": audienceView(privacy[0]),
+	}, nil
+}
+
+// handleStatus records a contact's status update and forwards it as a
+// status.received event.
+func handleStatus(userId string, client *whatsmeow.Client, evt *events.Message) {
+	msg := evt.Message
+	if msg.GetProtocolMessage() != nil || msg.GetReactionMessage() != nil {
+		return
+	}
+
+	status := storage.StatusUpdate{
+		ID:          evt.Info.ID,
+		From:        evt.Info.Sender.ToNonAD().String(),
+		ContactName: resolveContactName(userId, client, evt.Info.Sender),
+		Timestamp:   evt.Info.Timestamp.UTC().Format(time.RFC3339),
+	}
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		text := msg.GetExtendedTextMessage()
+		status.Type = "text"
+		status.Text = text.GetText()
+		if text.BackgroundArgb != nil {
+			status.BackgroundColor = formatColor(text.GetBackgroundArgb())
+		}
+		if text.Font != nil {
+			font := int32(text.GetFont())
+			status.Font = &font
+		}
+	case msg.GetConversation() != "":
+		status.Type = "text"
+		status.Text = msg.GetConversation()
+	case msg.GetImageMessage() != nil:
+		status.Type = "image"
+		status.Caption = msg.GetImageMessage().GetCaption()
+		status.Mimetype = msg.GetImageMessage().GetMimetype()
+	case msg.GetVideoMessage() != nil:
+		status.Type = "video"
+		status.Caption = msg.GetVideoMessage().GetCaption()
+		status.Mimetype = msg.GetVideoMessage().GetMimetype()
+	case msg.GetAudioMessage() != nil:
+		status.Type = "audio"
+		status.Mimetype = msg.GetAudioMessage().GetMimetype()
+	default:
+		status.Type = "other"
+	}
+
+	storage.RecordStatus(userId, status)
+	emitEvent(userId, "status.received", map[string]interface{}{
+		"id":              status.ID,
+		"from":            status.From,
+		"contactName":     status.ContactName,
+		"type":            status.Type,
+		"text":            status.Text,
+		"caption":         status.Caption,
+		"mimetype":        status.Mimetype,
+		"backgroundColor": status.BackgroundColor,
+		"timestamp":       status.Timestamp,
+	})
+}